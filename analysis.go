@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// MotifAnalysis : melodic statistics of a motif
+type MotifAnalysis struct {
+	Name          string       `json:"name"`
+	Key           string       `json:"key"`
+	Mode          string       `json:"mode"`
+	Percussion    bool         `json:"percussion"`
+	Notes         int          `json:"notes"` // sounding notes
+	Rests         int          `json:"rests"`
+	Lowest        string       `json:"lowest"`
+	Highest       string       `json:"highest"`
+	Ambitus       int          `json:"ambitus"`   // semitones from the lowest to the highest note
+	Intervals     map[int]int  `json:"intervals"` // count of each melodic interval in semitones, negative going down
+	Contour       string       `json:"contour"`   // Parsons code, * for the first note then u(p), d(own) or r(epeat)
+	Density       float64      `json:"density"`   // sounding notes per beat
+	NoteRestRatio *float64     `json:"noteRestRatio"`
+	LongestRepeat *MotifRepeat `json:"longestRepeat"` // nil when no interval pattern repeats
+	Beats         float64      `json:"beats"`
+	Seconds       float64      `json:"seconds"`
+}
+
+// MotifRepeat : an interval pattern heard more than once, wherever it is transposed to
+type MotifRepeat struct {
+	Length    int   `json:"length"`    // notes in the pattern
+	Intervals []int `json:"intervals"` // semitones between its notes
+	Beats     []int `json:"beats"`     // starting beat of each time it is heard
+}
+
+// take motif and return its melodic statistics
+func analyzeMotif(m Motif) MotifAnalysis {
+	m = joinTiedNotes(m)
+	a := MotifAnalysis{Name: m.Name, Key: m.Key, Mode: m.Mode, Percussion: m.Percussion, Intervals: map[int]int{}}
+	var sounding []MotifNote
+	noteUnits, restUnits := 0, 0
+	for _, n := range m.Notes {
+		if n.Value < 0 {
+			a.Rests++
+			restUnits += n.Duration
+			continue
+		}
+		sounding = append(sounding, n)
+		noteUnits += n.Duration
+	}
+	a.Notes = len(sounding)
+	end := getMotifEnd(m)
+	a.Beats = float64(end) / float64(getUnitsPerBeat(m.TimeSignature))
+	a.Seconds = getDurationInSeconds(end, m.Tempo, m.TimeSignature)
+	if a.Beats > 0 {
+		a.Density = float64(a.Notes) / a.Beats
+	}
+	if restUnits > 0 {
+		ratio := float64(noteUnits) / float64(restUnits)
+		a.NoteRestRatio = &ratio
+	}
+	if len(sounding) == 0 {
+		return a
+	}
+	lowest, highest := sounding[0], sounding[0]
+	var intervals []int
+	contour := []string{"*"}
+	for idx, n := range sounding {
+		if n.Value < lowest.Value {
+			lowest = n
+		}
+		if n.Value > highest.Value {
+			highest = n
+		}
+		if idx == 0 {
+			continue
+		}
+		interval := n.Value - sounding[idx-1].Value
+		intervals = append(intervals, interval)
+		a.Intervals[interval]++
+		switch {
+		case interval > 0:
+			contour = append(contour, "u")
+		case interval < 0:
+			contour = append(contour, "d")
+		default:
+			contour = append(contour, "r")
+		}
+	}
+	a.Lowest, a.Highest = lowest.Pitch, highest.Pitch
+	a.Ambitus = highest.Value - lowest.Value
+	a.Contour = strings.Join(contour, "")
+	a.LongestRepeat = getLongestRepeat(sounding, intervals)
+	return a
+}
+
+// find the longest run of intervals heard again later without overlapping itself
+// lengths of the common runs starting at each pair of intervals are counted back from the end
+// a row at a time so long motifs don't need a table of every pair
+func getLongestRepeat(sounding []MotifNote, intervals []int) *MotifRepeat {
+	n := len(intervals)
+	best, bestStart := 0, 0
+	next := make([]int, n+1)
+	for i := n - 1; i >= 0; i-- {
+		row := make([]int, n+1)
+		for j := n - 1; j > i; j-- {
+			if intervals[i] != intervals[j] {
+				continue
+			}
+			// a run can't reach past where its repeat starts
+			row[j] = minInt(next[j+1]+1, j-i)
+			if row[j] > best || (row[j] == best && i < bestStart) {
+				best, bestStart = row[j], i
+			}
+		}
+		next = row
+	}
+	if best == 0 {
+		return nil
+	}
+	pattern := intervals[bestStart : bestStart+best]
+	repeat := &MotifRepeat{Length: best + 1, Intervals: pattern}
+	for start := 0; start+best <= n; {
+		if equalInts(intervals[start:start+best], pattern) {
+			repeat.Beats = append(repeat.Beats, sounding[start].StartingBeat)
+			start += best
+		} else {
+			start++
+		}
+	}
+	return repeat
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// take motifs and return their statistics
+func analyzeMotifs(motifs []Motif) []MotifAnalysis {
+	var analyses []MotifAnalysis
+	for _, m := range motifs {
+		analyses = append(analyses, analyzeMotif(m))
+	}
+	return analyses
+}
+
+// write the statistics of each motif as a table
+func writeAnalysisTable(w io.Writer, analyses []MotifAnalysis) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for idx, a := range analyses {
+		name := a.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		fmt.Fprintf(tw, "motif %d\t%v\n", idx+1, name)
+		if a.Key != "" {
+			fmt.Fprintf(tw, "key\t%v %v\n", a.Key, a.Mode)
+		}
+		if a.Percussion {
+			fmt.Fprintf(tw, "percussion\tyes\n")
+		}
+		fmt.Fprintf(tw, "notes / rests\t%d / %d\n", a.Notes, a.Rests)
+		if a.Notes > 0 {
+			fmt.Fprintf(tw, "range\t%v - %v\n", a.Lowest, a.Highest)
+			fmt.Fprintf(tw, "ambitus\t%d semitones\n", a.Ambitus)
+			fmt.Fprintf(tw, "intervals\t%v\n", formatIntervalHistogram(a.Intervals))
+			fmt.Fprintf(tw, "contour\t%v\n", a.Contour)
+		}
+		fmt.Fprintf(tw, "density\t%.2f notes per beat\n", a.Density)
+		if a.NoteRestRatio != nil {
+			fmt.Fprintf(tw, "note / rest ratio\t%.2f\n", *a.NoteRestRatio)
+		} else {
+			fmt.Fprintf(tw, "note / rest ratio\tno rests\n")
+		}
+		if r := a.LongestRepeat; r != nil {
+			fmt.Fprintf(tw, "longest repeat\t%d notes %v at beats %v\n", r.Length, formatIntervals(r.Intervals), formatInts(r.Beats))
+		} else {
+			fmt.Fprintf(tw, "longest repeat\tnone\n")
+		}
+		fmt.Fprintf(tw, "length\t%.2f beats, %.2f seconds\n", a.Beats, a.Seconds)
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// intervals with their counts, from the largest fall to the largest leap
+func formatIntervalHistogram(histogram map[int]int) string {
+	var intervals []int
+	for interval := range histogram {
+		intervals = append(intervals, interval)
+	}
+	sort.Ints(intervals)
+	var counts []string
+	for _, interval := range intervals {
+		counts = append(counts, fmt.Sprintf("%+d:%d", interval, histogram[interval]))
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	return strings.Join(counts, " ")
+}
+
+func formatIntervals(intervals []int) string {
+	var s []string
+	for _, interval := range intervals {
+		s = append(s, fmt.Sprintf("%+d", interval))
+	}
+	return "(" + strings.Join(s, " ") + ")"
+}
+
+func formatInts(ints []int) string {
+	var s []string
+	for _, i := range ints {
+		s = append(s, fmt.Sprint(i))
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// chordQuality : a kind of chord, the semitones of its notes above the root and how it is written
+type chordQuality struct {
+	Name      string
+	Suffix    string // written after the root in a chord symbol
+	Intervals []int
+	Kind      string // MusicXML harmony kind
+}
+
+// chord qualities, ties between them go to the root nearest the bass and then to the quality listed first
+var chordQualities = []chordQuality{
+	{"major", "", []int{0, 4, 7}, "major"},
+	{"minor", "m", []int{0, 3, 7}, "minor"},
+	{"diminished", "dim", []int{0, 3, 6}, "diminished"},
+	{"augmented", "aug", []int{0, 4, 8}, "augmented"},
+	{"suspended fourth", "sus4", []int{0, 5, 7}, "suspended-fourth"},
+	{"suspended second", "sus2", []int{0, 2, 7}, "suspended-second"},
+	{"dominant seventh", "7", []int{0, 4, 7, 10}, "dominant"},
+	{"major seventh", "maj7", []int{0, 4, 7, 11}, "major-seventh"},
+	{"minor seventh", "m7", []int{0, 3, 7, 10}, "minor-seventh"},
+	{"half diminished", "m7b5", []int{0, 3, 6, 10}, "half-diminished"},
+	{"diminished seventh", "dim7", []int{0, 3, 6, 9}, "diminished-seventh"},
+	{"minor major seventh", "mMaj7", []int{0, 3, 7, 11}, "major-minor"},
+	{"major sixth", "6", []int{0, 4, 7, 9}, "major-sixth"},
+	{"minor sixth", "m6", []int{0, 3, 7, 9}, "minor-sixth"},
+}
+
+// Chord : notes sounding together named by their root, quality and bass
+type Chord struct {
+	Symbol       string   `json:"symbol"` // Cmaj7/E
+	Root         string   `json:"root"`
+	Quality      string   `json:"quality"`
+	Bass         string   `json:"bass"`      // lowest note, the root unless the chord is inverted
+	Inversion    int      `json:"inversion"` // 0 with the root in the bass, 1 with the third, 2 with the fifth and 3 with the seventh
+	StartingBeat int      `json:"startingBeat"`
+	Duration     int      `json:"duration"`
+	Values       []int    `json:"values"` // the notes of the chord, lowest first
+	Pitches      []string `json:"pitches"`
+}
+
+// ChordTrack : the chords heard across the melodic tracks of a file
+type ChordTrack struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Mode string `json:"mode"`
+	Tempo
+	TimeSignature
+	Chords []Chord `json:"chords"`
+}
+
+// the name of a pitch class as it is written in a chord symbol (C#)
+func getChordNoteName(pitchClass int) string {
+	name := config.Notes[pitchClass]
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// name the notes sounding together as a chord, false when they don't make one
+// every note is tried as the root and a quality matching all the notes beats one matching some of them,
+// then bigger qualities beat smaller ones and a root in the bass beats an inversion
+func nameChord(values []int) (Chord, bool) {
+	if len(values) == 0 {
+		return Chord{}, false
+	}
+	sort.Ints(values)
+	var pitchClasses []int
+	for _, v := range values {
+		pc := getValuePitchClass(v)
+		if !containsInt(pitchClasses, pc) {
+			pitchClasses = append(pitchClasses, pc)
+		}
+	}
+	bass := getValuePitchClass(values[0])
+	found := false
+	var best chordQuality
+	bestRoot, bestScore := 0, -1
+	for _, root := range pitchClasses {
+		var intervals []int
+		for _, pc := range pitchClasses {
+			intervals = append(intervals, floorMod(pc-root, 12))
+		}
+		for _, q := range chordQualities {
+			matched := 0
+			for _, i := range q.Intervals {
+				if containsInt(intervals, i) {
+					matched++
+				}
+			}
+			if matched < len(q.Intervals) {
+				continue
+			}
+			score := len(q.Intervals) * 4
+			if len(q.Intervals) == len(intervals) {
+				score += 100
+			}
+			if root == bass {
+				score++
+			}
+			if score > bestScore {
+				found, best, bestRoot, bestScore = true, q, root, score
+			}
+		}
+	}
+	if !found {
+		return Chord{}, false
+	}
+	c := Chord{
+		Root:    getChordNoteName(bestRoot),
+		Quality: best.Name,
+		Bass:    getChordNoteName(bass),
+		Values:  values,
+	}
+	for idx, i := range best.Intervals {
+		if i == floorMod(bass-bestRoot, 12) {
+			c.Inversion = idx
+		}
+	}
+	c.Symbol = c.Root + best.Suffix
+	if bass != bestRoot {
+		c.Symbol += "/" + c.Bass
+	}
+	for _, v := range values {
+		c.Pitches = append(c.Pitches, newNote(v, 0).Pitch)
+	}
+	return c, true
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, v := range ints {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+// the quality of a chord by name
+func getChordQuality(name string) chordQuality {
+	for _, q := range chordQualities {
+		if q.Name == name {
+			return q
+		}
+	}
+	return chordQualities[0]
+}
+
+// take the motifs of a file and return the chords their notes make where they sound together
+// the notes are cut wherever one starts or stops, slices shorter than a sixteenth are overlaps
+// of one note into the next rather than chords, and a chord held over changing notes stays one chord
+func detectChordTrack(motifs []Motif) ChordTrack {
+	ct := ChordTrack{Name: "chords", Tempo: Tempo{Type: "bpm", Units: midiDefaultTempo}, TimeSignature: TimeSignature{4, 4}}
+	var notes []MotifNote
+	for idx, m := range motifs {
+		if idx == 0 {
+			ct.Tempo, ct.TimeSignature = m.Tempo, m.TimeSignature
+		}
+		// percussion notes are drum keys so they make no chords
+		if m.Percussion {
+			continue
+		}
+		if ct.Key == "" {
+			ct.Key, ct.Mode = m.Key, m.Mode
+		}
+		for _, n := range joinTiedNotes(m).Notes {
+			if n.Value >= 0 {
+				n.StartingBeat += m.Offset
+				notes = append(notes, n)
+			}
+		}
+	}
+	var cuts []int
+	for _, n := range notes {
+		for _, c := range []int{n.StartingBeat, n.StartingBeat + n.Duration} {
+			if !containsInt(cuts, c) {
+				cuts = append(cuts, c)
+			}
+		}
+	}
+	sort.Ints(cuts)
+	minUnits := getUnitsPerBeat(ct.TimeSignature) / 4
+	current := -1 // the chord being held
+	for idx := 0; idx+1 < len(cuts); idx++ {
+		from, to := cuts[idx], cuts[idx+1]
+		if to-from < minUnits {
+			continue
+		}
+		var values []int
+		for _, n := range notes {
+			if n.StartingBeat < to && n.StartingBeat+n.Duration > from && !containsInt(values, n.Value) {
+				values = append(values, n.Value)
+			}
+		}
+		c, ok := nameChord(values)
+		switch {
+		case !ok:
+			current = -1
+		case current >= 0 && ct.Chords[current].Symbol == c.Symbol:
+			ct.Chords[current].Duration = to - ct.Chords[current].StartingBeat
+		default:
+			c.StartingBeat, c.Duration = from, to-from
+			ct.Chords = append(ct.Chords, c)
+			current = len(ct.Chords) - 1
+		}
+	}
+	return ct
+}
+
+// where a beat falls as bar.beat, both counted from 1
+func formatBarBeat(beat int, ts TimeSignature) string {
+	units := beat - 1
+	unitsPerBar := getUnitsPerBar(ts)
+	return fmt.Sprintf("%d.%d", units/unitsPerBar+1, units%unitsPerBar/getUnitsPerBeat(ts)+1)
+}
+
+// write the chords of a chord track as a table
+func writeChordTable(w io.Writer, ct ChordTrack) error {
+	if len(ct.Chords) == 0 {
+		_, err := fmt.Fprintln(w, "no chords")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "bar.beat\tbeats\tchord\tquality\tinversion\tnotes")
+	unitsPerBeat := float64(getUnitsPerBeat(ct.TimeSignature))
+	for _, c := range ct.Chords {
+		fmt.Fprintf(tw, "%v\t%.2f\t%v\t%v\t%d\t%v\n", formatBarBeat(c.StartingBeat, ct.TimeSignature), float64(c.Duration)/unitsPerBeat, c.Symbol, c.Quality, c.Inversion, strings.Join(c.Pitches, " "))
+	}
+	return tw.Flush()
+}
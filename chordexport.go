@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// formats chord tracks are exported to and the extension of each
+var chordFormats = map[string]string{"json": "json", "musicxml": "musicxml", "midi": "mid"}
+
+// ticks per quarter note of exported MIDI files
+const midiExportTicksPerBeat int = 480
+
+// velocity of the notes of exported chords
+const midiExportVelocity byte = 80
+
+// take a chord track and write it to a file in one of the chordFormats
+func saveChordTrack(ct ChordTrack, format string, filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return encodeChordTrack(ct, format, file)
+}
+
+func encodeChordTrack(ct ChordTrack, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		jsonData, err := json.MarshalIndent(ct, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(jsonData)
+		return err
+	case "musicxml":
+		return encodeChordMusicXML(ct, w)
+	case "midi":
+		return encodeMIDIFile(chordMIDIMap(ct), midiExportTicksPerBeat, w)
+	default:
+		return fmt.Errorf("unknown chord format %v", format)
+	}
+}
+
+// sharps (flats when negative) of the key signature of a key
+func getKeyFifths(key string, mode string) int {
+	tonic := getPitchClass(key)
+	if strings.ToLower(mode) == "minor" {
+		// the relative major sits a minor third above
+		tonic = floorMod(tonic+3, 12)
+	}
+	// each sharp moves the tonic up a fifth, keys past six sharps are written with flats
+	fifths := floorMod(tonic*7, 12)
+	if fifths > 6 {
+		fifths -= 12
+	}
+	return fifths
+}
+
+// the conductor track of a MIDI file with its tempo, time signature and key signature when the key is known
+func getMIDIConductorTrack(t Tempo, ts TimeSignature, key string, mode string) []midiFileEvent {
+	usPerBeat := 60000000 / t.Units
+	conductor := []midiFileEvent{
+		getMIDIMetaEvent(0, 0x51, []byte{byte(usPerBeat >> 16), byte(usPerBeat >> 8), byte(usPerBeat)}),
+		// the denominator is a power of two and a click every quarter note is 24 MIDI clocks
+		getMIDIMetaEvent(0, 0x58, []byte{byte(ts.Beat), byte(math.Log2(float64(ts.Unit))), 24, 8}),
+	}
+	if key != "" {
+		scale := byte(0)
+		if strings.ToLower(mode) == "minor" {
+			scale = 1
+		}
+		conductor = append(conductor, getMIDIMetaEvent(0, 0x59, []byte{byte(int8(getKeyFifths(key, mode))), scale}))
+	}
+	return conductor
+}
+
+// ticks of an exported MIDI file from the start to a number of motif units
+func getMIDIExportTicks(units int, ts TimeSignature) int {
+	return units * midiExportTicksPerBeat / getUnitsPerBeat(ts)
+}
+
+// take a chord track and return the tracks of a MIDI file, the conductor track
+// and a track with each chord's symbol as a text event over its notes
+func chordMIDIMap(ct ChordTrack) [][]midiFileEvent {
+	chords := []midiFileEvent{getMIDIMetaEvent(0, 0x03, []byte(ct.Name))}
+	for _, c := range ct.Chords {
+		start, end := getMIDIExportTicks(c.StartingBeat-1, ct.TimeSignature), getMIDIExportTicks(c.StartingBeat-1+c.Duration, ct.TimeSignature)
+		chords = append(chords, getMIDIMetaEvent(start, 0x01, []byte(c.Symbol)))
+		for _, v := range c.Values {
+			chords = append(chords, midiFileEvent{tick: start, data: []byte{0x90, byte(getMIDINote(v)), midiExportVelocity}})
+		}
+		for _, v := range c.Values {
+			chords = append(chords, midiFileEvent{tick: end, data: []byte{0x80, byte(getMIDINote(v)), 0}})
+		}
+	}
+	return [][]midiFileEvent{getMIDIConductorTrack(ct.Tempo, ct.TimeSignature, ct.Key, ct.Mode), chords}
+}
+
+// take a chord track and write it as a MusicXML score, each chord a harmony over its notes
+// chords are written in the note values they are held for, tied over bar lines, and the gaps between them are rests
+func encodeChordMusicXML(ct ChordTrack, w io.Writer) error {
+	score := musicXMLScore{Version: "4.0", PartList: []musicXMLScorePart{{ID: "P1", PartName: ct.Name}}}
+	ts := ct.TimeSignature
+	unitsPerBar := getUnitsPerBar(ts)
+	end := 0
+	for _, c := range ct.Chords {
+		if e := c.StartingBeat - 1 + c.Duration; e > end {
+			end = e
+		}
+	}
+	bars := (end + unitsPerBar - 1) / unitsPerBar
+	if bars == 0 {
+		bars = 1
+	}
+
+	var measures []musicXMLMeasure
+	next := 0 // the chord after the last one written
+	for bar := 0; bar < bars; bar++ {
+		measure := musicXMLMeasure{Number: bar + 1}
+		if bar == 0 {
+			measure.Elements = append(measure.Elements, getMusicXMLAttributes(ts, ct.Key, ct.Mode), getMusicXMLTempo(ct.Tempo))
+		}
+		from, to := bar*unitsPerBar, (bar+1)*unitsPerBar
+		position := from
+		rest := func(until int) {
+			for _, v := range getWrittenValues(position, until, ts) {
+				measure.Elements = append(measure.Elements, newMusicXMLNote(-1, v))
+			}
+			position = until
+		}
+		for idx := next; idx < len(ct.Chords); idx++ {
+			c := ct.Chords[idx]
+			start, stop := c.StartingBeat-1, c.StartingBeat-1+c.Duration
+			if start >= to {
+				break
+			}
+			if stop <= from {
+				continue
+			}
+			if start > position {
+				rest(start)
+			}
+			if start >= from {
+				quality := getChordQuality(c.Quality)
+				harmony := musicXMLHarmony{Inversion: c.Inversion}
+				harmony.RootStep, harmony.RootAlter = getMusicXMLStep(c.Root)
+				harmony.Kind.Text, harmony.Kind.Value = quality.Suffix, quality.Kind
+				if c.Bass != c.Root {
+					harmony.Bass = &musicXMLBass{}
+					harmony.Bass.Step, harmony.Bass.Alter = getMusicXMLStep(c.Bass)
+				}
+				measure.Elements = append(measure.Elements, harmony)
+			}
+			for _, v := range getWrittenValues(position, minInt(stop, to), ts) {
+				for k, value := range c.Values {
+					note := newMusicXMLNote(value, v)
+					if k > 0 {
+						note.Chord = &struct{}{}
+					}
+					note.tie(start < v.start, stop > v.start+v.duration)
+					measure.Elements = append(measure.Elements, note)
+				}
+				position += v.duration
+			}
+			if stop <= to {
+				next = idx + 1
+			}
+		}
+		rest(to)
+		measures = append(measures, measure)
+	}
+	score.Parts = []musicXMLPart{{ID: "P1", Measures: measures}}
+	return encodeMusicXMLScore(score, w)
+}
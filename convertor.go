@@ -7,23 +7,34 @@ import (
 	"io"
 	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
-	"github.com/go-audio/aiff"
 	"github.com/go-audio/audio"
 	"github.com/go-audio/generator"
 	"github.com/go-audio/midi"
-	"github.com/go-audio/wav"
 )
 
 // Setting global audio config for 16/44/mono
 const audioBitDepth int = 16
 const audioSampleRate int = 44100
 const midiNoteValueOffset int = -11
-const midiDurationValueDivisor int = 8
+const midiPanController uint8 = 10
+const midiBankMSBController uint8 = 0
+const midiBankLSBController uint8 = 32
+const midiDefaultTempo int = 120
 const defaultWaveForm generator.WaveType = generator.WaveSine
 const wavFile string = "wav"
+const aiffFile string = "aiff"
+
+// notes are rendered in mono and panned into the stereo output
+var voiceFormat = audio.FormatMono44100
+var audioFormat = audio.FormatStereo44100
+
+// log every note as it is parsed and rendered and the loudness of each render, set by -verbose
+var verbose bool
 
-var audioFormat = audio.FormatMono44100
 var waveForm = map[string]generator.WaveType{
 	"sine":     generator.WaveSine,
 	"triangle": generator.WaveTriangle,
@@ -31,29 +42,88 @@ var waveForm = map[string]generator.WaveType{
 	"saw":      generator.WaveSaw,
 }
 
-func convertMIDIFileToWAVFile(inputFileName string, outputFilePath string, wf string, c chan<- bool) {
-	success := false
-	// parse the MIDI file to Motivic format
-	motifs, err := parseMIDIFile(inputFileName)
-	if err != nil || len(motifs) == 0 {
-		fmt.Println("ERROR: parseMIDIFile", err)
-		c <- success
-		return
+// RenderOptions : settings for turning a Motif into audio
+type RenderOptions struct {
+	Format          string  // wav or aiff
+	WaveForm        string  // oscillator waveform, FM preset or soundfont
+	Tuning          string  // 12-tet, just, <n>-edo or the path of a Scala .scl file
+	ReferencePitch  float64 // A4 in Hz
+	KeyboardMapping string  // optional path of a Scala .kbm file
+	Pan             float64 // -1 (left) to 1 (right) for tracks without a MIDI pan
+	Vibrato         Vibrato
+	Glide           float64 // legato portamento time in seconds
+	Effects         []EffectSpec
+	Mastering       MasteringOptions
+	Metronome       Metronome
+	Parse           ParseOptions // how the motifs are read from the MIDI file before they are rendered
+	MIDI            bool         // also write the rendered motifs to a MIDI file next to the output
+	MusicXML        bool         // also write the rendered motifs as a MusicXML score next to the output
+}
+
+// ConversionResult : outcome of a conversion sent back over its channel
+type ConversionResult struct {
+	Success   bool
+	Loudness  *LoudnessReport
+	Manifest  *StemManifest  // stems rendered next to the output
+	MIDIFile  string         // the motifs written as MIDI next to the output
+	ScoreFile string         // the motifs written as a MusicXML score next to the output
+	Problems  []MotifProblem // why the motifs failed validation
+}
+
+// parse a MIDI file into motifs and run them through the parse options
+func parseMotifsFromMIDIFile(inputFileName string, opts ParseOptions) ([]Motif, error) {
+	motifs, err := parseMIDIFile(inputFileName, opts.Quantize)
+	if err != nil {
+		return nil, err
 	}
-	// for now Motivic only supports monophonic melodies so just grab the first track
-	if len(motifs) > 1 {
-		fmt.Println("ERROR: MIDI file is not monophonic")
-		c <- success
-		return
+	if len(motifs) == 0 {
+		return nil, errors.New("MIDI file has no notes")
 	}
-	motif := motifs[0]
-
-	for _, n := range motif.Notes {
-		fmt.Printf("MOTIF NOTE:\t%+v\n", n)
+	// notes are checked as they are written, a note held over a bar line as its tied pieces
+	if err = validateMotifs(notateMotifs(motifs)); err != nil {
+		return nil, err
+	}
+	if opts.Segment {
+		motifs = segmentMotifs(motifs)
+	}
+	if motifs, err = applyTransformChains(motifs, opts.Transforms); err != nil {
+		return nil, err
+	}
+	if opts.Variations.Count > 0 {
+		if motifs, err = generateVariations(motifs, opts.Variations); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Harmony != "" {
+		if motifs, err = harmonizeMotifs(motifs, opts.Harmony); err != nil {
+			return nil, err
+		}
 	}
+	// segmenting, transforms, variations and harmony can all move notes so the result is checked again
+	motifs = notateMotifs(motifs)
+	if err = validateMotifs(motifs); err != nil {
+		return nil, err
+	}
+	if verbose {
+		for _, m := range motifs {
+			for _, n := range m.Notes {
+				fmt.Printf("MOTIF NOTE:\t%v\t%+v\n", m.Name, n)
+			}
+		}
+	}
+	return motifs, nil
+}
 
-	// convert Motif to audio buffers
-	motifBuffers := motifAudioMap(motif, wf)
+func convertMIDIFileToWAVFile(inputFileName string, outputFilePath string, opts RenderOptions, c chan<- ConversionResult) {
+	success := ConversionResult{}
+	// parse the MIDI file to Motivic format
+	motifs, err := parseMotifsFromMIDIFile(inputFileName, opts.Parse)
+	if err != nil {
+		fmt.Println("ERROR: parseMotifsFromMIDIFile", err)
+		success.Problems = getMotifProblems(err)
+		c <- success
+		return
+	}
 	// ignore error if dir already exists
 	_ = os.Mkdir(outputFileDir, 0777)
 	// generate the audio file
@@ -64,13 +134,87 @@ func convertMIDIFileToWAVFile(inputFileName string, outputFilePath string, wf st
 		return
 	}
 	defer outputFile.Close()
-	if err := encodeAudioFile(wavFile, motifBuffers, outputFile); err != nil {
-		fmt.Println("ERROR: encodeAudioFile", err)
+	// convert Motifs to audio a block at a time so long files don't have to fit in memory
+	tracks := getRenderTracks(motifs, opts)
+	mix, err := newMixStream(tracks, opts.Effects)
+	if err != nil {
+		fmt.Println("ERROR: newMixStream", err)
 		c <- success
 		return
 	}
+	loudness, err := streamTracks(mix, opts.Mastering, opts.Format, outputFile)
+	if err != nil {
+		fmt.Println("ERROR: streamTracks", err)
+		c <- success
+		return
+	}
+	if verbose {
+		fmt.Printf("LOUDNESS:\t%+v\n", *loudness)
+	}
+
+	// multi-track files get a stem for each track and the click can have its own
+	manifest := newStemManifest(outputFilePath, motifs[0], loudness)
+	var stems []StemInfo
+	var stemTracks []RenderTrack
+	// the motifs as they were placed and counted in for the mix
+	var rendered []Motif
+	for _, track := range tracks[:len(motifs)] {
+		rendered = append(rendered, track.Motif)
+	}
+	if len(motifs) > 1 {
+		for idx, track := range tracks[:len(motifs)] {
+			m := track.Motif
+			stems = append(stems, StemInfo{
+				File:       getTrackStemPath(outputFilePath, idx, m.Name),
+				Track:      idx + 1,
+				Name:       m.Name,
+				Program:    m.Program,
+				Percussion: m.Percussion,
+				Pan:        getMotifPan(m, opts.Pan),
+			})
+			stemTracks = append(stemTracks, track)
+		}
+	}
+	if opts.Metronome.isEnabled() && opts.Metronome.Stem {
+		click := getClickTrack(rendered, opts.Metronome)
+		stems = append(stems, StemInfo{File: getClickStemPath(outputFilePath), Name: click.Motif.Name, Percussion: true})
+		stemTracks = append(stemTracks, click)
+	}
+	for idx, stem := range stems {
+		if err := renderStem(stemTracks[idx], opts.Mastering, loudness.GainDB, opts.Format, stem.File); err != nil {
+			fmt.Println("ERROR: renderStem", err)
+			c <- success
+			return
+		}
+		fmt.Println("Stem generated at", stem.File)
+		manifest.Stems = append(manifest.Stems, stem)
+	}
+	result := ConversionResult{Success: true, Loudness: loudness}
+	if len(manifest.Stems) > 0 {
+		result.Manifest = manifest
+	}
+	if opts.MIDI {
+		midiFilePath := strings.TrimSuffix(outputFilePath, filepath.Ext(outputFilePath)) + ".mid"
+		if err := saveMotifsMIDIFile(motifs, midiFilePath); err != nil {
+			fmt.Println("ERROR: saveMotifsMIDIFile", err)
+			c <- success
+			return
+		}
+		fmt.Println("MIDI file generated at", midiFilePath)
+		result.MIDIFile = midiFilePath
+	}
+	if opts.MusicXML {
+		scoreFilePath := strings.TrimSuffix(outputFilePath, filepath.Ext(outputFilePath)) + ".musicxml"
+		if err := saveMotifsMusicXMLFile(motifs, scoreFilePath); err != nil {
+			fmt.Println("ERROR: saveMotifsMusicXMLFile", err)
+			c <- success
+			return
+		}
+		fmt.Println("MusicXML score generated at", scoreFilePath)
+		result.ScoreFile = scoreFilePath
+	}
 	fmt.Println("Audio file generated at", outputFilePath)
-	c <- true
+	c <- result
 	return
 }
 
@@ -107,8 +251,15 @@ func parseJSONFile(filePath string) ([]Motif, error) {
 	return parsedTracks, err
 }
 
+// midiFileInfo : settings of a MIDI file shared by all of its tracks
+type midiFileInfo struct {
+	bpm          int
+	keySignature *KeyEstimate
+	ticksPerBeat int
+}
+
 // take a MIDI file on disk and return parsed music events (Motivic.Motif format)
-func parseMIDIFile(filePath string) ([]Motif, error) {
+func parseMIDIFile(filePath string, q Quantization) ([]Motif, error) {
 	var parsedTracks []Motif
 	var err error = nil
 	defer func() {
@@ -128,18 +279,97 @@ func parseMIDIFile(filePath string) ([]Motif, error) {
 		return parsedTracks, err
 	}
 
+	info := midiFileInfo{
+		bpm:          parseMIDITempo(decodedFile.Tracks),
+		keySignature: parseMIDIKeySignature(decodedFile.Tracks),
+		ticksPerBeat: int(decodedFile.TicksPerQuarterNote),
+	}
 	for _, t := range decodedFile.Tracks {
-		parsedTrack, err := parseMIDITrack(t)
-		if err != nil {
-			fmt.Println("ERROR parsing track", err)
-			return parsedTracks, err
+		rebaseMIDITrackTicks(t)
+		for _, part := range splitMIDITrackByChannel(t) {
+			parsedTrack, err := parseMIDITrack(part, info, q)
+			if err != nil {
+				fmt.Println("ERROR parsing track", err)
+				return parsedTracks, err
+			}
+			// tracks without notes (like the tempo map of type 1 files) aren't motifs
+			if len(parsedTrack.Notes) == 0 {
+				continue
+			}
+			parsedTracks = append(parsedTracks, parsedTrack)
 		}
-		parsedTracks = append(parsedTracks, parsedTrack)
 	}
 	return parsedTracks, err
 }
 
-func parseMIDITrack(track *midi.Track) (Motif, error) {
+// split a track into a track for each channel its notes are played on
+// so a type 0 file, or a track mixing the drum channel with pitched ones, gives a motif for each instrument
+// meta events go to every part while channel messages only go to the part of their channel
+func splitMIDITrackByChannel(track *midi.Track) []*midi.Track {
+	parts := map[uint8]*midi.Track{}
+	var channels []uint8
+	for _, e := range track.Events {
+		if _, ok := parts[e.MsgChan]; !ok && e.MsgType == midi.EventByteMap["NoteOn"] {
+			parts[e.MsgChan] = &midi.Track{}
+			channels = append(channels, e.MsgChan)
+		}
+	}
+	if len(channels) < 2 {
+		return []*midi.Track{track}
+	}
+	lastTicks := map[uint8]uint64{}
+	for _, e := range track.Events {
+		for _, ch := range channels {
+			if e.MsgType < midi.EventByteMap["Meta"] && e.MsgChan != ch {
+				continue
+			}
+			// each part gets its own copy since its delta times count from its own previous event
+			c := *e
+			c.TimeDelta = uint32(c.AbsTicks - lastTicks[ch])
+			lastTicks[ch] = c.AbsTicks
+			parts[ch].Events = append(parts[ch].Events, &c)
+		}
+	}
+	var split []*midi.Track
+	for _, ch := range channels {
+		split = append(split, parts[ch])
+	}
+	return split
+}
+
+// the decoder keeps counting ticks from one track to the next
+// so shift a track's events back to count from the start of the file
+func rebaseMIDITrackTicks(track *midi.Track) {
+	if len(track.Events) == 0 {
+		return
+	}
+	trackStart := track.Events[0].AbsTicks - uint64(track.Events[0].TimeDelta)
+	for _, e := range track.Events {
+		e.AbsTicks -= trackStart
+	}
+}
+
+// the tempo of a MIDI file, type 1 files keep it on their first (conductor) track
+func parseMIDITempo(tracks []*midi.Track) int {
+	for _, t := range tracks {
+		if bpm := t.Tempo(); bpm > 0 {
+			return bpm
+		}
+	}
+	return midiDefaultTempo
+}
+
+// the name of a track from its sequence/track name meta event
+func parseMIDITrackName(track *midi.Track) string {
+	for _, e := range track.Events {
+		if e.SeqTrackName != "" {
+			return e.SeqTrackName
+		}
+	}
+	return ""
+}
+
+func parseMIDITrack(track *midi.Track, info midiFileInfo, q Quantization) (Motif, error) {
 	// serialize midi.Track to Motivic.Motif
 	// TODO: remove hardcoded time signature - parse from MIDI file
 	fmt.Printf("\n*midi.Track: \t%+v\n\n", track)
@@ -148,12 +378,17 @@ func parseMIDITrack(track *midi.Track) (Motif, error) {
 	if track == nil {
 		return m, fmt.Errorf("ERROR: parseMIDITrack() - track is nil")
 	}
-	bpm := track.Events[0].Bpm
-	t := Tempo{Type: "bpm", Units: int(bpm)}
+	t := Tempo{Type: "bpm", Units: info.bpm}
 	ts := TimeSignature{4, 4}
+	tb := newMIDITimebase(info.ticksPerBeat, ts)
+	program := parseMIDIProgram(track)
+	bank := parseMIDIBank(track)
+	percussion := isPercussionTrack(track)
+	pan := parseMIDIPan(track)
+	bends, modulation := parseMIDIControlCurves(track, tb)
 	var parsedEvents []MotifNote
-	for _, e := range track.AbsoluteEvents() {
-		parsedEvent, err := parseMIDIEvent(e)
+	for _, e := range quantizeMIDIEvents(track.AbsoluteEvents(), tb, q) {
+		parsedEvent, err := parseMIDIEvent(e, tb)
 		if err != nil {
 			fmt.Println(err)
 			return m, err
@@ -161,23 +396,65 @@ func parseMIDITrack(track *midi.Track) (Motif, error) {
 		parsedEvents = append(parsedEvents, parsedEvent)
 	}
 	parsedEvents = getNotesWithInsertedRests(parsedEvents)
-	m = Motif{Notes: parsedEvents, Tempo: t, TimeSignature: ts}
+	m = Motif{Name: parseMIDITrackName(track), Notes: parsedEvents, Tempo: t, TimeSignature: ts, Program: program, Bank: bank, Percussion: percussion, Pan: pan, PitchBend: bends, Modulation: modulation}
+	m.setKey(info.keySignature)
+	m.computeRelativeFields()
 	return m, nil
 }
 
+// find the first pan controller (CC10) of a track and return it from -1 (left) to 1 (right)
+func parseMIDIPan(track *midi.Track) *float64 {
+	for _, e := range track.Events {
+		if e.MsgType == midi.EventByteMap["ControlChange"] && e.Controller == midiPanController {
+			pan := math.Max(-1, math.Min(1, float64(int(e.NewValue)-64)/63))
+			return &pan
+		}
+	}
+	return nil
+}
+
+// find the first program change of a track so renderers can pick an instrument
+func parseMIDIProgram(track *midi.Track) int {
+	for _, e := range track.Events {
+		if e.MsgType == midi.EventByteMap["ProgramChange"] {
+			return int(e.NewProgram)
+		}
+	}
+	return 0
+}
+
+// find the bank selected when the first program change of a track is played
+// banks are numbered by their MSB (CC0) the way GS and General MIDI 2 files select them,
+// XG files leave the MSB at 0 and select the bank with the LSB (CC32)
+func parseMIDIBank(track *midi.Track) int {
+	msb, lsb := 0, 0
+	for _, e := range track.Events {
+		if e.MsgType == midi.EventByteMap["ProgramChange"] {
+			break
+		}
+		if e.MsgType == midi.EventByteMap["ControlChange"] {
+			switch e.Controller {
+			case midiBankMSBController:
+				msb = int(e.NewValue)
+			case midiBankLSBController:
+				lsb = int(e.NewValue)
+			}
+		}
+	}
+	if msb == 0 {
+		return lsb
+	}
+	return msb
+}
+
 func getNotesWithInsertedRests(events []MotifNote) []MotifNote {
 	// MIDI doesn't treat rests as events so
 	// fabricate rest notes to fill in the gaps in parsedEvents
 	var notes []MotifNote
-	for i, e := range events {
-		beatPosition := 1
-		if i != 0 {
-			// this is the first event
-			prevNote := events[i-1]
-			beatPosition = prevNote.StartingBeat + prevNote.Duration
-		}
-		// this is not the first event
-		if e.StartingBeat != beatPosition {
+	// notes can overlap so rests start where the longest sounding note so far ends
+	beatPosition := 1
+	for _, e := range events {
+		if e.StartingBeat > beatPosition {
 			// there is a gap where a rest should go
 			// for now, Note with negative value is a Rest
 			rest := newNote(-1, e.StartingBeat-beatPosition)
@@ -190,6 +467,9 @@ func getNotesWithInsertedRests(events []MotifNote) []MotifNote {
 		}
 		// there is no gap so add the note
 		notes = append(notes, e)
+		if end := e.StartingBeat + e.Duration; end > beatPosition {
+			beatPosition = end
+		}
 	}
 	return notes
 }
@@ -199,48 +479,135 @@ func convertMIDINote(note int) int {
 	return note + midiNoteValueOffset
 }
 
-func convertMIDINoteDuration(dur int) int {
-	return dur / midiDurationValueDivisor
+// converts Motivic.Note.value back to a MIDI note number
+func getMIDINote(value int) int {
+	if value < 0 {
+		return value
+	}
+	return value - midiNoteValueOffset
 }
 
-func parseMIDIEvent(e *midi.AbsEv) (MotifNote, error) {
+func parseMIDIEvent(e *midi.AbsEv, tb midiTimebase) (MotifNote, error) {
 	// TODO: serialize midi.Event to Motivic.Note
-	fmt.Printf("MIDI EVENT:\t%+v\n", e)
+	if verbose {
+		fmt.Printf("MIDI EVENT:\t%+v\n", e)
+	}
 	// TODO: make sure conversion from MIDINote to MotifNote.value is correct!
 	// TODO: handle RESTS!!!
 	value := convertMIDINote(e.MIDINote)
-	// TODO: conversion from ticks to MotifNote.duration is correct!
-	// TODO: make sure that these are always both ints!
-	duration := convertMIDINoteDuration(e.Duration)
+	if value <= 0 {
+		// notes below c0 would read as rests, 0 isn't a pitch so validation reports them
+		value = 0
+	}
+	// the start and end are converted rather than the duration so notes stay back to back
+	start := tb.getUnits(e.Start)
+	duration := tb.getUnits(e.End()) - start
+	if duration < 1 {
+		// notes shorter than a unit still sound
+		duration = 1
+	}
 	n := newNote(value, duration)
 	mn := MotifNote{
-		Note: n,
-		// TODO: make sure this conversion from ticks to MotifNote.startingBeat is correct
-		// TODO: make sure that these are always both ints!
-		StartingBeat: convertMIDINoteDuration(e.Start) + 1,
+		Note:         n,
+		StartingBeat: start + 1,
 	}
 	return mn, nil
 }
 
-// take motif and return slice of audio buffers
-func motifAudioMap(m Motif, voice string) []audio.FloatBuffer {
-	var buffers []audio.FloatBuffer
+// the motif's MIDI pan wins over the pan chosen for the render
+func getMotifPan(m Motif, fallback float64) float64 {
+	if m.Pan != nil {
+		return *m.Pan
+	}
+	return math.Max(-1, math.Min(1, fallback))
+}
+
+// left and right gains of a constant power pan law
+func getPanGains(pan float64) (float64, float64) {
+	angle := (pan + 1) * math.Pi / 4
+	return math.Cos(angle), math.Sin(angle)
+}
+
+// take motif and return the events of its MIDI track on a channel
+func motifMIDIMap(m Motif, channel int) []midiFileEvent {
+	events := []midiFileEvent{
+		getMIDIMetaEvent(0, 0x03, []byte(m.Name)),
+		{tick: 0, data: []byte{0xC0 | byte(channel), byte(m.Program)}},
+	}
+	if m.Bank > 0 {
+		// the bank is selected ahead of the program change
+		events = append([]midiFileEvent{events[0], {tick: 0, data: []byte{0xB0 | byte(channel), midiBankMSBController, byte(m.Bank)}}}, events[1:]...)
+	}
+	if m.Pan != nil {
+		pan := int(math.Round((*m.Pan + 1) / 2 * 127))
+		events = append(events, midiFileEvent{tick: 0, data: []byte{0xB0 | byte(channel), midiPanController, byte(pan)}})
+	}
+	// the note off of each note waiting for the next piece of its tie, by value and the unit it ends on
+	open := map[[2]int]int{}
 	for _, n := range m.Notes {
-		freq := getPitchFrequency(n.Name, n.Octave)
-		// TODO: duration needs to be converted to seconds?
-		// TODO: fix this - right now am rounding up to nearest second
-		ds := getDurationInSeconds(n.Duration, m.Tempo, m.TimeSignature)
-		fmt.Println("AUDIO NOTE DATA:", n.Name, n.Octave, n.Pitch, "freq:", freq, "secs:", ds)
-		// TODO: handle rests!!!
-		buf := generateAudioFrequency(freq, ds, voice)
-		buffers = append(buffers, *buf)
-	}
-	return buffers
+		if n.Value < 0 {
+			continue
+		}
+		start, end := getWrittenNoteThirds(n, m.Offset, m.TimeSignature)
+		startTick, endTick := getMIDIExportTicks(start, m.TimeSignature)/3, getMIDIExportTicks(end, m.TimeSignature)/3
+		note := byte(getMIDINote(n.Value))
+		idx, tied := open[[2]int{n.Value, n.StartingBeat}]
+		if tied && (n.Tie == tieContinue || n.Tie == tieStop) {
+			delete(open, [2]int{n.Value, n.StartingBeat})
+			events[idx].tick = endTick
+		} else {
+			events = append(events,
+				midiFileEvent{tick: startTick, data: []byte{0x90 | byte(channel), note, midiExportVelocity}},
+				midiFileEvent{tick: endTick, data: []byte{0x80 | byte(channel), note, 0}})
+			idx = len(events) - 1
+		}
+		if n.Tie == tieStart || n.Tie == tieContinue {
+			open[[2]int{n.Value, n.StartingBeat + n.Duration}] = idx
+		}
+	}
+	// notes ending on a tick are let go before the notes starting on it so a repeated note isn't cut short
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].tick != events[j].tick {
+			return events[i].tick < events[j].tick
+		}
+		return events[i].data[0]&0xF0 == 0x80 && events[j].data[0]&0xF0 == 0x90
+	})
+	return events
 }
 
-// take motif and return slice of MIDI buffers
-func motifMIDIMap(m Motif) {
-	// TODO: write this
+// take motifs and write them as a MIDI file, a track for each after the conductor track
+// percussion goes on the General MIDI drum channel and the other tracks take the rest in turn
+func saveMotifsMIDIFile(motifs []Motif, filePath string) error {
+	if len(motifs) == 0 {
+		return errors.New("no motifs to write")
+	}
+	first := motifs[0]
+	key, mode := "", ""
+	for _, m := range motifs {
+		if !m.Percussion && m.Key != "" {
+			key, mode = m.Key, m.Mode
+			break
+		}
+	}
+	tracks := [][]midiFileEvent{getMIDIConductorTrack(first.Tempo, first.TimeSignature, key, mode)}
+	channel := 0
+	for _, m := range motifs {
+		if m.Percussion {
+			tracks = append(tracks, motifMIDIMap(m, int(midiPercussionChannel)))
+			continue
+		}
+		tracks = append(tracks, motifMIDIMap(m, channel))
+		channel = (channel + 1) % 16
+		if channel == int(midiPercussionChannel) {
+			channel++
+		}
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return encodeMIDIFile(tracks, midiExportTicksPerBeat, file)
 }
 
 // take motif and return JSON representation
@@ -249,7 +616,11 @@ func motifJSONMap(m Motif) {
 }
 
 // take frequency, duration, bit depth, and sample rate and return audio buffer of one note
+// voice is either an oscillator waveform or the name of an FM preset
 func generateAudioFrequency(freq float64, durSecs float64, voice string) *audio.FloatBuffer {
+	if preset, ok := fmPresets[voice]; ok {
+		return generateFMAudioFrequency(freq, durSecs, preset)
+	}
 	wf := waveForm[voice]
 	if wf == 0 {
 		wf = defaultWaveForm
@@ -260,51 +631,50 @@ func generateAudioFrequency(freq float64, durSecs float64, voice string) *audio.
 	osc.Amplitude = factor
 	// buf.Data slice has length bitDepth * seconds
 	data := make([]float64, int(math.Ceil(float64(audioSampleRate)*durSecs)))
-	buf := &audio.FloatBuffer{Data: data, Format: audioFormat}
+	buf := &audio.FloatBuffer{Data: data, Format: voiceFormat}
 	osc.Fill(buf)
 	return buf
 }
 
-func encodeWAVFile(bufs []audio.FloatBuffer, w io.WriteSeeker) error {
-	// APPROACH: iterate through buffers and encode each one sequentially
-	e := wav.NewEncoder(w, bufs[0].PCMFormat().SampleRate, audioBitDepth, bufs[0].PCMFormat().NumChannels, 1)
-	for _, b := range bufs {
-		err := e.Write(b.AsIntBuffer())
-		if err != nil {
-			return err
-		}
-	}
-	return e.Close()
+// midiFileEvent : a channel or meta message of a MIDI file track at its tick
+type midiFileEvent struct {
+	tick int
+	data []byte // the message without its delta time
 }
 
-func encodeAIFFile(bufs []audio.FloatBuffer, w io.WriteSeeker) error {
-	e := aiff.NewEncoder(w,
-		bufs[0].PCMFormat().SampleRate,
-		audioBitDepth,
-		bufs[0].PCMFormat().NumChannels)
-	for _, b := range bufs {
-		err := e.Write(b.AsIntBuffer())
-		if err != nil {
+// take tracks of events and write a type 1 standard MIDI file
+// the go-audio encoder can't write text or time signature events so the chunks are written here
+func encodeMIDIFile(tracks [][]midiFileEvent, ticksPerBeat int, w io.Writer) error {
+	header := []byte("MThd")
+	header = append(header, 0, 0, 0, 6, 0, 1, byte(len(tracks)>>8), byte(len(tracks)), byte(ticksPerBeat>>8), byte(ticksPerBeat))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, events := range tracks {
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].tick < events[j].tick
+		})
+		var chunk []byte
+		tick := 0
+		for _, e := range events {
+			chunk = append(chunk, midi.EncodeVarint(uint32(e.tick-tick))...)
+			chunk = append(chunk, e.data...)
+			tick = e.tick
+		}
+		chunk = append(chunk, 0, 0xFF, 0x2F, 0)
+		size := len(chunk)
+		trackHeader := append([]byte("MTrk"), byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+		if _, err := w.Write(append(trackHeader, chunk...)); err != nil {
 			return err
 		}
 	}
-	return e.Close()
+	return nil
 }
 
-// take slice of audio buffers and write audio file
-func encodeAudioFile(format string, bufs []audio.FloatBuffer, w io.WriteSeeker) error {
-	switch format {
-	case "wav":
-		return encodeWAVFile(bufs, w)
-	case "aiff":
-		return encodeAIFFile(bufs, w)
-	default:
-		return errors.New("unknown format")
-	}
-}
-
-func encodeMIDIFile(bufs []audio.FloatBuffer, w io.WriteSeeker) {
-	// TODO: write this
+// a meta event of a MIDI file
+func getMIDIMetaEvent(tick int, cmd byte, data []byte) midiFileEvent {
+	e := append([]byte{0xFF, cmd}, midi.EncodeVarint(uint32(len(data)))...)
+	return midiFileEvent{tick: tick, data: append(e, data...)}
 }
 
 func encodeJSONFile(jsonData []byte, filePath string) {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/midi"
+)
+
+// General MIDI reserves channel 10 (index 9) for percussion
+const midiPercussionChannel uint8 = 9
+
+// fade applied when a drum sound is cut off by the end of its note
+const drumFadeOutSecs float64 = 0.005
+
+// DrumSound : synthesis recipe for one General MIDI percussion key
+// a drum is a pitched body sweeping from Tone down to ToneEnd mixed with filtered noise
+type DrumSound struct {
+	Tone       float64 // starting frequency of the body in Hz, 0 for noise only
+	ToneEnd    float64 // frequency the body sweeps down to
+	Sweep      float64 // time constant of the pitch sweep in seconds
+	ToneDecay  float64 // time constant of the body decay in seconds
+	Noise      float64 // level of the noise component (0-1)
+	NoiseDecay float64 // time constant of the noise decay in seconds
+	HighPass   float64 // cutoff of the noise high-pass filter in Hz
+}
+
+var (
+	kickDrum    = DrumSound{Tone: 150, ToneEnd: 45, Sweep: 0.04, ToneDecay: 0.35, Noise: 0.05, NoiseDecay: 0.01, HighPass: 1000}
+	snareDrum   = DrumSound{Tone: 200, ToneEnd: 170, Sweep: 0.02, ToneDecay: 0.1, Noise: 0.7, NoiseDecay: 0.15, HighPass: 1500}
+	sideStick   = DrumSound{Tone: 500, ToneEnd: 400, Sweep: 0.005, ToneDecay: 0.03, Noise: 0.4, NoiseDecay: 0.02, HighPass: 3000}
+	handClap    = DrumSound{Noise: 1, NoiseDecay: 0.12, HighPass: 1200}
+	closedHat   = DrumSound{Noise: 1, NoiseDecay: 0.04, HighPass: 7000}
+	pedalHat    = DrumSound{Noise: 1, NoiseDecay: 0.06, HighPass: 6000}
+	openHat     = DrumSound{Noise: 1, NoiseDecay: 0.35, HighPass: 7000}
+	crashCymbal = DrumSound{Noise: 1, NoiseDecay: 1.2, HighPass: 4000}
+	rideCymbal  = DrumSound{Tone: 3500, ToneEnd: 3500, Sweep: 1, ToneDecay: 0.6, Noise: 0.6, NoiseDecay: 0.8, HighPass: 6000}
+	tambourine  = DrumSound{Noise: 1, NoiseDecay: 0.2, HighPass: 8000}
+	cowbell     = DrumSound{Tone: 800, ToneEnd: 800, Sweep: 1, ToneDecay: 0.15}
+	clickSound  = DrumSound{Tone: 1000, ToneEnd: 1000, Sweep: 1, ToneDecay: 0.015}
+	bellSound   = DrumSound{Tone: 1600, ToneEnd: 1600, Sweep: 1, ToneDecay: 0.04}
+	percussion  = DrumSound{Tone: 300, ToneEnd: 250, Sweep: 0.02, ToneDecay: 0.08, Noise: 0.3, NoiseDecay: 0.05, HighPass: 2000}
+)
+
+// newTom returns a tom tuned to freq
+func newTom(freq float64) DrumSound {
+	return DrumSound{Tone: freq * 1.6, ToneEnd: freq, Sweep: 0.05, ToneDecay: 0.3, Noise: 0.1, NoiseDecay: 0.03, HighPass: 800}
+}
+
+// General MIDI percussion key map
+var drumKit = map[int]DrumSound{
+	33: clickSound,
+	34: bellSound,
+	35: kickDrum,
+	36: kickDrum,
+	37: sideStick,
+	38: snareDrum,
+	39: handClap,
+	40: snareDrum,
+	41: newTom(80),
+	42: closedHat,
+	43: newTom(95),
+	44: pedalHat,
+	45: newTom(110),
+	46: openHat,
+	47: newTom(130),
+	48: newTom(150),
+	49: crashCymbal,
+	50: newTom(175),
+	51: rideCymbal,
+	52: crashCymbal,
+	53: rideCymbal,
+	54: tambourine,
+	55: crashCymbal,
+	56: cowbell,
+	57: crashCymbal,
+	59: rideCymbal,
+}
+
+// take a General MIDI percussion key and duration and return audio buffer of one drum hit
+func generateDrumAudio(key int, durSecs float64) *audio.FloatBuffer {
+	factor := float64(audio.IntMaxSignedValue(audioBitDepth))
+	data := make([]float64, int(math.Ceil(float64(audioSampleRate)*durSecs)))
+	buf := &audio.FloatBuffer{Data: data, Format: voiceFormat}
+	// rests are silence
+	if key < 0 {
+		return buf
+	}
+	d, ok := drumKit[key]
+	if !ok {
+		d = percussion
+	}
+	// seed the noise with the key so the same drum always sounds the same
+	noise := rand.New(rand.NewSource(int64(key)))
+	dt := 1 / float64(audioSampleRate)
+	rc := 1 / (2 * math.Pi * math.Max(d.HighPass, 1))
+	hpCoef := rc / (rc + dt)
+	fadeSamples := int(math.Ceil(drumFadeOutSecs * float64(audioSampleRate)))
+	var phase, prevNoise, filtered float64
+	for i := range data {
+		t := float64(i) * dt
+		sample := 0.0
+		if d.Tone > 0 {
+			freq := d.ToneEnd + (d.Tone-d.ToneEnd)*math.Exp(-t/d.Sweep)
+			phase = math.Mod(phase+2*math.Pi*freq*dt, 2*math.Pi)
+			sample += math.Sin(phase) * math.Exp(-t/d.ToneDecay) * (1 - d.Noise)
+		}
+		if d.Noise > 0 {
+			// one pole high-pass over white noise
+			white := noise.Float64()*2 - 1
+			filtered = hpCoef * (filtered + white - prevNoise)
+			prevNoise = white
+			sample += filtered * math.Exp(-t/d.NoiseDecay) * d.Noise
+		}
+		// avoid a click when the note is shorter than the drum
+		if remaining := len(data) - i; remaining < fadeSamples {
+			sample *= float64(remaining) / float64(fadeSamples)
+		}
+		data[i] = sample * factor
+	}
+	return buf
+}
+
+// a track is percussive when its notes are played on the General MIDI drum channel
+func isPercussionTrack(track *midi.Track) bool {
+	for _, e := range track.Events {
+		if e.MsgType == midi.EventByteMap["NoteOn"] {
+			return e.MsgChan == midiPercussionChannel
+		}
+	}
+	return false
+}
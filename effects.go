@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// longest tail an effect chain may add to the rendered audio
+const maxEffectTailSecs float64 = 10
+
+// EffectSpec : declarative description of one effect in a chain
+// a chain is a []EffectSpec so it can be saved and loaded as a JSON preset
+type EffectSpec struct {
+	Type   string             `json:"type"`
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// Effect : audio processor that works in place on interleaved samples
+// effects keep their state between calls so audio can be processed in blocks
+type Effect interface {
+	Process(data []float64)
+	// Tail is how many seconds the effect keeps sounding after its input stops
+	Tail() float64
+}
+
+// default parameters of each effect type
+var effectDefaults = map[string]map[string]float64{
+	"reverb":   {"size": 0.7, "damping": 0.5, "mix": 0.3},
+	"delay":    {"time": 0.3, "feedback": 0.4, "mix": 0.3},
+	"lowpass":  {"cutoff": 2000, "resonance": 0.707},
+	"highpass": {"cutoff": 200, "resonance": 0.707},
+	"chorus":   {"rate": 1.5, "depth": 0.003, "delay": 0.02, "mix": 0.5},
+}
+
+// look up an effect parameter, falling back to its default
+func (s EffectSpec) param(name string) float64 {
+	if v, ok := s.Params[name]; ok {
+		return v
+	}
+	return effectDefaults[s.Type][name]
+}
+
+// Effect factory
+func newEffect(spec EffectSpec, numChannels int) (Effect, error) {
+	defaults, ok := effectDefaults[spec.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown effect %v", spec.Type)
+	}
+	for name := range spec.Params {
+		if _, ok := defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown %v parameter %v", spec.Type, name)
+		}
+	}
+	switch spec.Type {
+	case "reverb":
+		return newReverb(spec, numChannels), nil
+	case "delay":
+		return newDelay(spec, numChannels), nil
+	case "lowpass", "highpass":
+		return newFilter(spec, numChannels), nil
+	default:
+		return newChorus(spec, numChannels), nil
+	}
+}
+
+// take an effect chain string and return its specs
+// effects are separated by ; and parameters by , e.g. "lowpass:cutoff=800;reverb:size=0.9,mix=0.4"
+// a chain can also be given as a JSON preset
+func parseEffectChain(s string) ([]EffectSpec, error) {
+	var chain []EffectSpec
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return chain, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &chain); err != nil {
+			return nil, err
+		}
+		return chain, validateEffectChain(chain)
+	}
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		t, params, options, err := parseChainStep(e)
+		if err != nil {
+			return nil, err
+		}
+		if len(options) > 0 {
+			return nil, fmt.Errorf("invalid effect parameter in %v", e)
+		}
+		chain = append(chain, EffectSpec{Type: t, Params: params})
+	}
+	return chain, validateEffectChain(chain)
+}
+
+// take one step of a chain string ("type:name=value,name=value") and return its type,
+// numeric parameters and any options given as words
+func parseChainStep(s string) (string, map[string]float64, map[string]string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	params := map[string]float64{}
+	options := map[string]string{}
+	if len(parts) == 2 {
+		for _, p := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) != 2 {
+				return "", nil, nil, fmt.Errorf("invalid parameter %v", p)
+			}
+			name, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				params[name] = v
+			} else {
+				options[name] = strings.ToLower(value)
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), params, options, nil
+}
+
+func validateEffectChain(chain []EffectSpec) error {
+	for _, spec := range chain {
+		if _, err := newEffect(spec, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// take an effect chain string or the path of a JSON preset and return its specs
+func loadEffectChain(s string) ([]EffectSpec, error) {
+	if strings.HasSuffix(strings.ToLower(s), ".json") && fileExists(s) {
+		preset, err := ioutil.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		return parseEffectChain(string(preset))
+	}
+	return parseEffectChain(s)
+}
+
+// write an effect chain to disk as a JSON preset
+func saveEffectChain(filePath string, chain []EffectSpec) error {
+	preset, err := json.MarshalIndent(chain, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, preset, 0666)
+}
+
+// build the processors of a chain along with the number of frames their tails ring on for
+func newEffectChain(chain []EffectSpec, numChannels int) ([]Effect, int, error) {
+	var effects []Effect
+	tail := 0.0
+	for _, spec := range chain {
+		e, err := newEffect(spec, numChannels)
+		if err != nil {
+			return nil, 0, err
+		}
+		effects = append(effects, e)
+		tail += e.Tail()
+	}
+	return effects, int(math.Min(tail, maxEffectTailSecs) * float64(audioSampleRate)), nil
+}
+
+// describe a chain the way parseEffectChain reads it
+func formatEffectChain(chain []EffectSpec) string {
+	var effects []string
+	for _, spec := range chain {
+		effects = append(effects, formatChainStep(spec.Type, spec.Params, nil))
+	}
+	return strings.Join(effects, ";")
+}
+
+// describe one step of a chain the way parseChainStep reads it
+func formatChainStep(t string, params map[string]float64, options map[string]string) string {
+	var pairs []string
+	for name, v := range params {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", name, v))
+	}
+	for name, v := range options {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", name, v))
+	}
+	sort.Strings(pairs)
+	if len(pairs) == 0 {
+		return t
+	}
+	return t + ":" + strings.Join(pairs, ",")
+}
+
+// circular delay line
+type delayLine struct {
+	buf []float64
+	pos int
+}
+
+func newDelayLine(samples int) *delayLine {
+	if samples < 1 {
+		samples = 1
+	}
+	return &delayLine{buf: make([]float64, samples)}
+}
+
+// sample written len(buf) samples ago
+func (d *delayLine) read() float64 {
+	return d.buf[d.pos]
+}
+
+// sample written delay samples ago with linear interpolation
+func (d *delayLine) readAt(delay float64) float64 {
+	n := len(d.buf)
+	pos := float64(d.pos) - delay
+	for pos < 0 {
+		pos += float64(n)
+	}
+	i := int(pos) % n
+	frac := pos - math.Floor(pos)
+	return d.buf[i]*(1-frac) + d.buf[(i+1)%n]*frac
+}
+
+func (d *delayLine) write(v float64) {
+	d.buf[d.pos] = v
+	d.pos = (d.pos + 1) % len(d.buf)
+}
+
+// Delay : feedback delay
+type Delay struct {
+	lines    []*delayLine
+	feedback float64
+	mix      float64
+	time     float64
+}
+
+func newDelay(spec EffectSpec, numChannels int) *Delay {
+	d := &Delay{
+		feedback: math.Max(0, math.Min(spec.param("feedback"), 0.95)),
+		mix:      spec.param("mix"),
+		time:     math.Max(spec.param("time"), 0.001),
+	}
+	for ch := 0; ch < numChannels; ch++ {
+		d.lines = append(d.lines, newDelayLine(int(d.time*float64(audioSampleRate))))
+	}
+	return d
+}
+
+// Process delays each channel independently
+func (d *Delay) Process(data []float64) {
+	numChannels := len(d.lines)
+	for i, in := range data {
+		line := d.lines[i%numChannels]
+		delayed := line.read()
+		line.write(in + delayed*d.feedback)
+		data[i] = in*(1-d.mix) + delayed*d.mix
+	}
+}
+
+// Tail is the time for the repeats to fall by 60dB
+func (d *Delay) Tail() float64 {
+	if d.feedback <= 0 {
+		return d.time
+	}
+	return d.time * math.Log(0.001) / math.Log(d.feedback)
+}
+
+// Filter : resonant low-pass or high-pass biquad (RBJ cookbook)
+type Filter struct {
+	b0, b1, b2, a1, a2 float64
+	// per channel history
+	x1, x2, y1, y2 []float64
+}
+
+func newFilter(spec EffectSpec, numChannels int) *Filter {
+	nyquist := float64(audioSampleRate) / 2
+	cutoff := math.Max(10, math.Min(spec.param("cutoff"), nyquist*0.99))
+	q := math.Max(spec.param("resonance"), 0.1)
+	w0 := 2 * math.Pi * cutoff / float64(audioSampleRate)
+	alpha := math.Sin(w0) / (2 * q)
+	cosw0 := math.Cos(w0)
+	a0 := 1 + alpha
+	f := &Filter{
+		a1: -2 * cosw0 / a0,
+		a2: (1 - alpha) / a0,
+		x1: make([]float64, numChannels),
+		x2: make([]float64, numChannels),
+		y1: make([]float64, numChannels),
+		y2: make([]float64, numChannels),
+	}
+	if spec.Type == "highpass" {
+		f.b0 = (1 + cosw0) / 2 / a0
+		f.b1 = -(1 + cosw0) / a0
+	} else {
+		f.b0 = (1 - cosw0) / 2 / a0
+		f.b1 = (1 - cosw0) / a0
+	}
+	f.b2 = f.b0
+	return f
+}
+
+// Process filters each channel independently
+func (f *Filter) Process(data []float64) {
+	numChannels := len(f.x1)
+	for i, x := range data {
+		ch := i % numChannels
+		y := f.b0*x + f.b1*f.x1[ch] + f.b2*f.x2[ch] - f.a1*f.y1[ch] - f.a2*f.y2[ch]
+		f.x2[ch], f.x1[ch] = f.x1[ch], x
+		f.y2[ch], f.y1[ch] = f.y1[ch], y
+		data[i] = y
+	}
+}
+
+// Tail of a filter is negligible
+func (f *Filter) Tail() float64 {
+	return 0
+}
+
+// Chorus : delay line modulated by a sine LFO
+type Chorus struct {
+	lines []*delayLine
+	rate  float64
+	depth float64
+	delay float64
+	mix   float64
+	phase float64
+}
+
+func newChorus(spec EffectSpec, numChannels int) *Chorus {
+	c := &Chorus{
+		rate:  spec.param("rate"),
+		depth: math.Abs(spec.param("depth")),
+		delay: math.Abs(spec.param("delay")),
+		mix:   spec.param("mix"),
+	}
+	maxDelay := int((c.delay+c.depth)*float64(audioSampleRate)) + 2
+	for ch := 0; ch < numChannels; ch++ {
+		c.lines = append(c.lines, newDelayLine(maxDelay))
+	}
+	return c
+}
+
+// Process modulates each channel with the LFO offset by a quarter cycle per channel
+// so stereo output gets some width
+func (c *Chorus) Process(data []float64) {
+	numChannels := len(c.lines)
+	for i, in := range data {
+		ch := i % numChannels
+		lfo := math.Sin(c.phase + float64(ch)*math.Pi/2)
+		delay := (c.delay + c.depth*lfo) * float64(audioSampleRate)
+		line := c.lines[ch]
+		line.write(in)
+		wet := line.readAt(delay + 1)
+		data[i] = in*(1-c.mix) + wet*c.mix
+		if ch == numChannels-1 {
+			c.phase = math.Mod(c.phase+2*math.Pi*c.rate/float64(audioSampleRate), 2*math.Pi)
+		}
+	}
+}
+
+// Tail is the longest delay of the chorus
+func (c *Chorus) Tail() float64 {
+	return c.delay + c.depth
+}
+
+// Freeverb comb and all-pass tunings at 44.1kHz
+var reverbCombTunings = []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+var reverbAllPassTunings = []int{556, 441, 341, 225}
+
+// right channel tunings are spread so the reverb is decorrelated
+const reverbStereoSpread int = 23
+
+// Freeverb input and wet gains
+const reverbInputGain float64 = 0.015
+const reverbWetScale float64 = 3
+
+type combFilter struct {
+	line     *delayLine
+	filtered float64
+}
+
+// Reverb : Schroeder/Moorer style algorithmic reverb (Freeverb topology)
+type Reverb struct {
+	combs     [][]*combFilter
+	allPasses [][]*delayLine
+	feedback  float64
+	damping   float64
+	mix       float64
+	size      float64
+}
+
+func newReverb(spec EffectSpec, numChannels int) *Reverb {
+	size := math.Max(0, math.Min(spec.param("size"), 1))
+	r := &Reverb{
+		feedback: 0.7 + 0.28*size,
+		damping:  math.Max(0, math.Min(spec.param("damping"), 1)),
+		mix:      spec.param("mix"),
+		size:     size,
+	}
+	scale := float64(audioSampleRate) / 44100
+	for ch := 0; ch < numChannels; ch++ {
+		var combs []*combFilter
+		for _, t := range reverbCombTunings {
+			combs = append(combs, &combFilter{line: newDelayLine(int(float64(t+ch*reverbStereoSpread) * scale))})
+		}
+		var allPasses []*delayLine
+		for _, t := range reverbAllPassTunings {
+			allPasses = append(allPasses, newDelayLine(int(float64(t+ch*reverbStereoSpread)*scale)))
+		}
+		r.combs = append(r.combs, combs)
+		r.allPasses = append(r.allPasses, allPasses)
+	}
+	return r
+}
+
+// Process runs parallel damped combs into serial all-passes for each channel
+func (r *Reverb) Process(data []float64) {
+	numChannels := len(r.combs)
+	for i, in := range data {
+		ch := i % numChannels
+		wet := 0.0
+		for _, c := range r.combs[ch] {
+			out := c.line.read()
+			c.filtered = out*(1-r.damping) + c.filtered*r.damping
+			c.line.write(in*reverbInputGain + c.filtered*r.feedback)
+			wet += out
+		}
+		for _, ap := range r.allPasses[ch] {
+			delayed := ap.read()
+			ap.write(wet + delayed*0.5)
+			wet = delayed - wet
+		}
+		data[i] = in*(1-r.mix) + wet*reverbWetScale*r.mix
+	}
+}
+
+// Tail is the time for the longest comb to decay by 60dB
+func (r *Reverb) Tail() float64 {
+	longest := float64(reverbCombTunings[len(reverbCombTunings)-1]) / 44100
+	return longest * math.Log(0.001) / math.Log(r.feedback)
+}
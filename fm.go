@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-audio/audio"
+)
+
+// Envelope : ADSR amplitude envelope (times in seconds, sustain as a 0-1 level)
+type Envelope struct {
+	Attack  float64 `json:"attack"`
+	Decay   float64 `json:"decay"`
+	Sustain float64 `json:"sustain"`
+	Release float64 `json:"release"`
+}
+
+// FMOperator : a single sine operator of an FM voice
+type FMOperator struct {
+	Ratio float64 `json:"ratio"` // frequency multiple of the note frequency
+	Index float64 `json:"index"` // modulation index when the operator modulates another one
+	Envelope
+}
+
+// FMPreset : a stack of FM operators
+// Operators[0] is the carrier and every other operator modulates the one before it,
+// so a preset with 2 operators is a classic 2-op voice and 4 operators is a 4-op stack
+type FMPreset struct {
+	Operators []FMOperator `json:"operators"`
+}
+
+var fmPresets = map[string]FMPreset{
+	"bell": {Operators: []FMOperator{
+		{Ratio: 1, Index: 1, Envelope: Envelope{Attack: 0.002, Decay: 2.5, Sustain: 0, Release: 0.3}},
+		{Ratio: 3.5, Index: 4.5, Envelope: Envelope{Attack: 0.001, Decay: 1.8, Sustain: 0, Release: 0.3}},
+	}},
+	"epiano": {Operators: []FMOperator{
+		{Ratio: 1, Index: 1, Envelope: Envelope{Attack: 0.002, Decay: 1.2, Sustain: 0.4, Release: 0.15}},
+		{Ratio: 1, Index: 1.6, Envelope: Envelope{Attack: 0.001, Decay: 0.9, Sustain: 0.2, Release: 0.15}},
+		{Ratio: 14, Index: 0.6, Envelope: Envelope{Attack: 0.001, Decay: 0.08, Sustain: 0, Release: 0.05}},
+		{Ratio: 1, Index: 0.3, Envelope: Envelope{Attack: 0.001, Decay: 0.5, Sustain: 0.3, Release: 0.1}},
+	}},
+	"bass": {Operators: []FMOperator{
+		{Ratio: 1, Index: 1, Envelope: Envelope{Attack: 0.003, Decay: 0.4, Sustain: 0.7, Release: 0.05}},
+		{Ratio: 0.5, Index: 3.2, Envelope: Envelope{Attack: 0.001, Decay: 0.25, Sustain: 0.25, Release: 0.05}},
+	}},
+}
+
+// amplitude of an ADSR envelope at time t for a note gated for gateSecs
+func (e Envelope) level(t float64, gateSecs float64) float64 {
+	if t >= gateSecs {
+		if e.Release <= 0 {
+			return 0
+		}
+		// release from wherever the envelope was when the gate closed
+		return e.level(gateSecs-1e-9, gateSecs) * math.Max(0, 1-(t-gateSecs)/e.Release)
+	}
+	if t < e.Attack {
+		return t / e.Attack
+	}
+	t -= e.Attack
+	if t < e.Decay {
+		return 1 - (1-e.Sustain)*(t/e.Decay)
+	}
+	return e.Sustain
+}
+
+// take frequency and duration and return audio buffer of one note played by an FM preset
+func generateFMAudioFrequency(freq float64, durSecs float64, preset FMPreset) *audio.FloatBuffer {
+	factor := float64(audio.IntMaxSignedValue(audioBitDepth))
+	data := make([]float64, int(math.Ceil(float64(audioSampleRate)*durSecs)))
+	buf := &audio.FloatBuffer{Data: data, Format: voiceFormat}
+	// rests and empty presets are silence
+	if freq == 0 || len(preset.Operators) == 0 {
+		return buf
+	}
+	// the release stage has to fit inside the note so the next note starts from silence
+	gateSecs := math.Max(0, durSecs-preset.Operators[0].Release)
+	phases := make([]float64, len(preset.Operators))
+	for i := range data {
+		t := float64(i) / float64(audioSampleRate)
+		mod := 0.0
+		// walk the stack from the top modulator down to the carrier
+		for opIdx := len(preset.Operators) - 1; opIdx >= 0; opIdx-- {
+			op := preset.Operators[opIdx]
+			out := math.Sin(phases[opIdx]+mod) * op.level(t, gateSecs)
+			phases[opIdx] = math.Mod(phases[opIdx]+2*math.Pi*freq*op.Ratio/float64(audioSampleRate), 2*math.Pi)
+			if opIdx > 0 {
+				mod = out * op.Index
+			} else {
+				data[i] = out * factor
+			}
+		}
+	}
+	return buf
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// voicing styles of a generated accompaniment, each a pattern of chord tones
+// (0 the root, 1 the third, 2 the fifth, 3 the root an octave up) played one after another in eighth notes,
+// block chords hold every tone together
+var harmonyStyles = map[string][]int{
+	"block":    nil,
+	"arpeggio": {0, 1, 2, 3, 2, 1},
+	"alberti":  {0, 2, 1, 2},
+}
+
+// the degrees (0 for I) each degree of a major or minor key is heard moving to,
+// tonic chords go anywhere, subdominants lead on to dominants and dominants resolve to the tonic
+var harmonyProgressions = map[int][]int{
+	0: {1, 2, 3, 4, 5, 6},
+	1: {4, 6},
+	2: {5, 3},
+	3: {4, 0, 1, 6},
+	4: {0, 5},
+	5: {1, 3, 4},
+	6: {0},
+}
+
+// how much a good move, the tonic at the start and a cadence at the end count against how well a chord fits the melody
+const harmonyProgressionWeight float64 = 0.3
+const harmonyTonicWeight float64 = 0.5
+const harmonyCadenceWeight float64 = 1
+
+// accompaniment roots are voiced from this note up an octave (c3)
+const harmonyLowestRoot int = 37
+
+// the scale chords are built from, the major or minor scale of the motif's key
+// with the raised seventh of the harmonic minor so the dominant of a minor key is major
+func getHarmonyScale(m Motif) Scale {
+	scale := m.getScale()
+	mode := "major"
+	if strings.Contains(scale.Mode, "minor") || scale.Mode == "aeolian" || scale.Mode == "dorian" || scale.Mode == "phrygian" {
+		mode = "harmonic minor"
+	}
+	harmony, _ := newScale(config.Notes[scale.Tonic], mode)
+	return harmony
+}
+
+// the triad on a degree of a scale, lowest note first
+func getScaleTriad(scale Scale, degree int) []int {
+	return []int{scale.getScaleValue(degree, 0), scale.getScaleValue(degree+2, 0), scale.getScaleValue(degree+4, 0)}
+}
+
+// how well a triad fits the melody over a stretch, the share of the melody's sounding time that is on its notes
+// with the note on the strong beat counting double
+func getTriadFit(triad []int, notes []MotifNote, from int, to int) float64 {
+	fit, total := 0.0, 0.0
+	for _, n := range notes {
+		start, end := n.StartingBeat-1, n.StartingBeat-1+n.Duration
+		if n.Value < 0 || start >= to || end <= from {
+			continue
+		}
+		weight := float64(minInt(end, to) - maxInt(start, from))
+		if start <= from && end > from {
+			weight *= 2
+		}
+		total += weight
+		for _, v := range triad {
+			if getValuePitchClass(v) == getValuePitchClass(n.Value) {
+				fit += weight
+				break
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return fit / total
+}
+
+// the scale degrees of the chords under a melody, one for each strong beat
+// the chords are found together (Viterbi) so each is picked for how it fits the melody, the chord before it
+// and, at the ends, for starting on the tonic and closing with a dominant to tonic cadence
+func getHarmonyDegrees(m Motif, scale Scale, span int) []int {
+	end := getMotifEnd(m)
+	steps := (end + span - 1) / span
+	scores := make([][7]float64, steps)
+	from := make([][7]int, steps)
+	for step := 0; step < steps; step++ {
+		for degree := 0; degree < 7; degree++ {
+			fit := getTriadFit(getScaleTriad(scale, degree), m.Notes, step*span, (step+1)*span)
+			if step == 0 && degree == 0 {
+				fit += harmonyTonicWeight
+			}
+			if step == steps-1 && degree == 0 {
+				fit += harmonyCadenceWeight
+			}
+			if step == steps-2 && steps > 2 && degree == 4 {
+				fit += harmonyCadenceWeight / 2
+			}
+			if step == 0 {
+				scores[step][degree] = fit
+				continue
+			}
+			best := -1
+			bestScore := 0.0
+			for prev := 0; prev < 7; prev++ {
+				score := scores[step-1][prev] + getProgressionScore(prev, degree)
+				if best < 0 || score > bestScore {
+					best, bestScore = prev, score
+				}
+			}
+			scores[step][degree] = bestScore + fit
+			from[step][degree] = best
+		}
+	}
+	degrees := make([]int, steps)
+	for degree := 1; degree < 7; degree++ {
+		if scores[steps-1][degree] > scores[steps-1][degrees[steps-1]] {
+			degrees[steps-1] = degree
+		}
+	}
+	for step := steps - 1; step > 0; step-- {
+		degrees[step-1] = from[step][degrees[step]]
+	}
+	return degrees
+}
+
+// how good a move from one chord to another sounds, holding a chord is neither good nor bad
+func getProgressionScore(from int, to int) float64 {
+	if from == to {
+		return 0
+	}
+	if containsInt(harmonyProgressions[from], to) {
+		return harmonyProgressionWeight
+	}
+	return -harmonyProgressionWeight
+}
+
+// the units between chord changes, the strong beats of the time signature
+// bars of an even number of four or more beats change chords on their first and middle beats
+func getHarmonicRhythm(ts TimeSignature) int {
+	if ts.Beat >= 4 && ts.Beat%2 == 0 {
+		return getUnitsPerBar(ts) / 2
+	}
+	return getUnitsPerBar(ts)
+}
+
+// take the motifs of a file and return them with an accompaniment for the first melodic one
+// in a voicing style of harmonyStyles, chords are taken from its key and change on the strong beats
+func harmonizeMotifs(motifs []Motif, style string) ([]Motif, error) {
+	pattern, ok := harmonyStyles[style]
+	if !ok {
+		return nil, fmt.Errorf("unknown harmony style %v", style)
+	}
+	melody, err := getQueryMotif(motifs)
+	if err != nil {
+		return nil, err
+	}
+	if getMotifEnd(melody) == 0 {
+		return nil, errors.New("melody has no notes to harmonize")
+	}
+	if melody.Key == "" {
+		melody.setKey(nil)
+	}
+	scale := getHarmonyScale(melody)
+	span := getHarmonicRhythm(melody.TimeSignature)
+	step := getUnitsPerBeat(melody.TimeSignature) / 2
+
+	harmony := Motif{
+		ID:            "harmony",
+		Name:          "harmony",
+		Key:           melody.Key,
+		Mode:          melody.Mode,
+		KeyConfidence: melody.KeyConfidence,
+		KeySource:     melody.KeySource,
+		Offset:        melody.Offset,
+		Tempo:         melody.Tempo,
+		TimeSignature: melody.TimeSignature,
+	}
+	if melody.Name != "" {
+		harmony.Name = melody.Name + " harmony"
+	}
+	for idx, degree := range getHarmonyDegrees(melody, scale, span) {
+		triad := getScaleTriad(scale, degree)
+		// voice the chord in close position from its root in the octave above the lowest root
+		shift := floorDiv(harmonyLowestRoot+11-triad[0], 12) * 12
+		tones := []int{triad[0] + shift, triad[1] + shift, triad[2] + shift, triad[0] + shift + 12}
+		start := idx*span + 1
+		if pattern == nil {
+			for _, v := range tones[:3] {
+				harmony.Notes = append(harmony.Notes, MotifNote{Note: newNote(v, span), StartingBeat: start})
+			}
+			continue
+		}
+		for k := 0; k*step < span; k++ {
+			duration := minInt(step, span-k*step)
+			harmony.Notes = append(harmony.Notes, MotifNote{Note: newNote(tones[pattern[k%len(pattern)]], duration), StartingBeat: start + k*step})
+		}
+	}
+	harmony.computeRelativeFields()
+	return append(motifs, harmony), nil
+}
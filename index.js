@@ -11,6 +11,23 @@ const uploadBtn = formEl.querySelector('#upload');
 const outputNameEl = formEl.querySelector("#output-name");
 const waveFormEl = formEl.querySelector("#waveform");
 const fileInputEl = formEl.querySelector("#upload-file");
+const tuningEl = formEl.querySelector("#tuning");
+const referencePitchEl = formEl.querySelector("#reference-pitch");
+const scalaFileEl = formEl.querySelector("#scala-file");
+const panEl = formEl.querySelector("#pan");
+const vibratoDepthEl = formEl.querySelector("#vibrato-depth");
+const glideEl = formEl.querySelector("#glide");
+const effectsEl = formEl.querySelector("#effects");
+const normalizeEl = formEl.querySelector("#normalize");
+const clickEl = formEl.querySelector("#click");
+const countInEl = formEl.querySelector("#count-in");
+const quantizeEl = formEl.querySelector("#quantize");
+const swingEl = formEl.querySelector("#swing");
+const segmentEl = formEl.querySelector("#segment");
+const variationsEl = formEl.querySelector("#variations");
+const seedEl = formEl.querySelector("#seed");
+const harmonizeEl = formEl.querySelector("#harmonize");
+const musicXMLEl = formEl.querySelector("#musicxml");
 const loadingIcon = `&#8635;`;
 const messages = {
     "arrow-up": `&#8679;`,
@@ -74,6 +91,28 @@ async function uploadClick(e) {
     formData.append('myMIDIFile', files[0]);
     formData.append(outputNameEl.name, outputNameEl.value);
     formData.append(waveFormEl.name, waveFormEl.value);
+    formData.append(tuningEl.name, tuningEl.value);
+    formData.append(referencePitchEl.name, referencePitchEl.value);
+    formData.append(panEl.name, panEl.value);
+    formData.append(vibratoDepthEl.name, vibratoDepthEl.value);
+    formData.append(glideEl.name, glideEl.value);
+    formData.append(effectsEl.name, effectsEl.value);
+    formData.append(normalizeEl.name, normalizeEl.value);
+    formData.append(clickEl.name, clickEl.value === 'false' ? 'false' : 'true');
+    formData.append('clickStem', clickEl.value === 'stem' ? 'true' : 'false');
+    formData.append(countInEl.name, countInEl.value);
+    formData.append(quantizeEl.name, parseInt(quantizeEl.value, 10));
+    formData.append('triplets', quantizeEl.value.endsWith('t') ? 'true' : 'false');
+    formData.append(swingEl.name, swingEl.value);
+    formData.append(segmentEl.name, segmentEl.value);
+    formData.append(variationsEl.name, variationsEl.value);
+    formData.append(seedEl.name, seedEl.value);
+    formData.append(harmonizeEl.name, harmonizeEl.value);
+    formData.append('midi', harmonizeEl.value ? 'true' : 'false');
+    formData.append(musicXMLEl.name, musicXMLEl.value);
+    if (scalaFileEl.files.length) {
+        formData.append(scalaFileEl.name, scalaFileEl.files[0]);
+    }
     let data = await awaitFetch(...getFetchArgs(formData));
     console.log('API response from /upload/midi...');
     console.dir(data);
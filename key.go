@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-audio/midi"
+)
+
+// where the key of a motif came from
+const keySourceMIDI string = "midi"
+const keySourceDetected string = "detected"
+const keySourceUser string = "user"
+
+// Krumhansl-Kessler key profiles, how well each pitch class above the tonic fits the key
+var keyProfiles = map[string][]float64{
+	"major": {6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88},
+	"minor": {6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17},
+}
+
+// profiles are tried in this order so ties go to the major key
+var keyProfileModes = []string{"major", "minor"}
+
+// KeyEstimate : the key and mode of a motif and how sure we are of it
+type KeyEstimate struct {
+	Key        string  // tonic name (c, f#)
+	Mode       string  // major or minor
+	Confidence float64 // 1 for a key signature, the profile correlation (0-1) for a detected key
+	Source     string  // midi or detected
+}
+
+// find the first key signature meta event of a MIDI file
+// type 1 files keep it on their first (conductor) track
+func parseMIDIKeySignature(tracks []*midi.Track) *KeyEstimate {
+	for _, t := range tracks {
+		for _, e := range t.Events {
+			if e.MsgType != midi.EventByteMap["Meta"] || e.Cmd != midi.MetaByteMap["Key Signature"] {
+				continue
+			}
+			// the decoder reads the signed count of sharps (flats when negative) as a byte
+			sharps := int(int8(e.Key))
+			tonic := floorMod(sharps*7, 12)
+			mode := "major"
+			if e.Scale == 1 {
+				// the relative minor sits a minor third below
+				tonic = floorMod(tonic-3, 12)
+				mode = "minor"
+			}
+			return &KeyEstimate{Key: config.Notes[tonic], Mode: mode, Confidence: 1, Source: keySourceMIDI}
+		}
+	}
+	return nil
+}
+
+// estimate the key of a motif with the Krumhansl-Schmuckler algorithm,
+// correlating its pitch class durations against the profile of each major and minor key
+// returns nil when the motif has no pitched notes
+func detectKey(notes []MotifNote) *KeyEstimate {
+	var durations [12]float64
+	total := 0.0
+	for _, n := range notes {
+		if n.Value < 0 || n.Duration <= 0 {
+			continue
+		}
+		durations[getValuePitchClass(n.Value)] += float64(n.Duration)
+		total += float64(n.Duration)
+	}
+	if total == 0 {
+		return nil
+	}
+	var best *KeyEstimate
+	bestCorrelation := math.Inf(-1)
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, mode := range keyProfileModes {
+			profile := keyProfiles[mode]
+			rotated := make([]float64, 12)
+			for i := range rotated {
+				rotated[i] = profile[floorMod(i-tonic, 12)]
+			}
+			r := getCorrelation(durations[:], rotated)
+			if r > bestCorrelation {
+				bestCorrelation = r
+				best = &KeyEstimate{Key: config.Notes[tonic], Mode: mode, Source: keySourceDetected}
+			}
+		}
+	}
+	best.Confidence = math.Max(0, bestCorrelation)
+	return best
+}
+
+// Pearson correlation of two series of the same length, 0 when either is flat
+func getCorrelation(x []float64, y []float64) float64 {
+	meanX, meanY := 0.0, 0.0
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(len(x))
+	meanY /= float64(len(y))
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
+
+// set the key of a pitched motif from the file's key signature or, without one, from its notes
+func (m *Motif) setKey(keySignature *KeyEstimate) {
+	if m.Percussion {
+		return
+	}
+	key := keySignature
+	if key == nil {
+		key = detectKey(m.Notes)
+	}
+	if key == nil {
+		return
+	}
+	m.Key = key.Key
+	m.Mode = key.Mode
+	m.KeyConfidence = key.Confidence
+	m.KeySource = key.Source
+}
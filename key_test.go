@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-audio/midi"
+)
+
+// notes of a melody given as pitch classes in octave 4 and durations
+func testMelody(pitchClasses []int, durations []int) []MotifNote {
+	var notes []MotifNote
+	for i, pc := range pitchClasses {
+		value := -1
+		if pc >= 0 {
+			value = 1 + pc + 4*12
+		}
+		notes = append(notes, MotifNote{Note: newNote(value, durations[i])})
+	}
+	return notes
+}
+
+func TestDetectKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		pitchClasses []int
+		durations    []int
+		key, mode    string
+	}{
+		{"c major scale", []int{0, 2, 4, 5, 7, 9, 11, 0}, []int{8, 4, 4, 4, 8, 4, 4, 16}, "c", "major"},
+		{"g major arpeggio", []int{7, 11, 2, 6, 7}, []int{8, 4, 4, 4, 16}, "g", "major"},
+		{"a natural minor scale", []int{9, 11, 0, 2, 4, 5, 7, 9}, []int{8, 4, 8, 4, 8, 4, 4, 16}, "a", "minor"},
+		{"d harmonic minor", []int{2, 4, 5, 7, 9, 10, 1, 2}, []int{8, 4, 8, 4, 8, 4, 4, 16}, "d", "minor"},
+		{"e flat major", []int{3, 5, 7, 8, 10, 0, 2, 3}, []int{8, 4, 8, 4, 8, 4, 4, 16}, "d#", "major"},
+		// rests don't count towards the key
+		{"f major with rests", []int{5, -1, 9, 0, -1, 5}, []int{8, 64, 4, 4, 64, 16}, "f", "major"},
+	}
+	for _, tt := range tests {
+		got := detectKey(testMelody(tt.pitchClasses, tt.durations))
+		if got == nil || got.Key != tt.key || got.Mode != tt.mode {
+			t.Errorf("%v: got %+v, want %v %v", tt.name, got, tt.key, tt.mode)
+			continue
+		}
+		if got.Source != keySourceDetected || got.Confidence <= 0.5 || got.Confidence > 1 {
+			t.Errorf("%v: got source %v confidence %v", tt.name, got.Source, got.Confidence)
+		}
+	}
+	if got := detectKey(testMelody([]int{-1, -1}, []int{16, 16})); got != nil {
+		t.Errorf("rests only: got %+v, want no key", got)
+	}
+}
+
+func TestParseMIDIKeySignature(t *testing.T) {
+	tests := []struct {
+		key   int32
+		scale uint32
+		tonic string
+		mode  string
+	}{
+		{0, 0, "c", "major"},
+		{0, 1, "a", "minor"},
+		{2, 0, "d", "major"},
+		{4, 1, "c#", "minor"},
+		// flats are decoded as the byte of a negative count
+		{256 - 3, 0, "d#", "major"},
+		{256 - 3, 1, "c", "minor"},
+		{256 - 7, 0, "b", "major"},
+	}
+	for _, tt := range tests {
+		track := &midi.Track{Events: []*midi.Event{
+			{MsgType: midi.EventByteMap["Meta"], Cmd: midi.MetaByteMap["Key Signature"], Key: tt.key, Scale: tt.scale},
+		}}
+		got := parseMIDIKeySignature([]*midi.Track{{}, track})
+		if got == nil || got.Key != tt.tonic || got.Mode != tt.mode || got.Confidence != 1 || got.Source != keySourceMIDI {
+			t.Errorf("key %d scale %d: got %+v, want %v %v", tt.key, tt.scale, got, tt.tonic, tt.mode)
+		}
+	}
+	if got := parseMIDIKeySignature([]*midi.Track{{}}); got != nil {
+		t.Errorf("no key signature: got %+v", got)
+	}
+}
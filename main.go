@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -17,12 +18,49 @@ import (
 )
 
 var (
-	flagMode     = flag.String("mode", "http", "The app mode (cli or http)")
-	flagInput    = flag.String("input", "", "The file to convert")
-	flagFormat   = flag.String("format", "wav", "The format to convert to (wav or aiff)")
-	flagOutput   = flag.String("output", "out", "The output filename")
-	flagWaveForm = flag.String("waveform", "sine", "The oscillator waveform to use")
-	outputDirs   = []string{"input", "output"}
+	flagMode      = flag.String("mode", "http", "The app mode (cli, inspect, analyze, search, chords or http)")
+	flagInput     = flag.String("input", "", "The file to convert")
+	flagFormat    = flag.String("format", "wav", "The format to convert to (wav or aiff)")
+	flagOutput    = flag.String("output", "out", "The output filename")
+	flagWaveForm  = flag.String("waveform", "sine", "The oscillator waveform or FM preset (bell, epiano, bass) to use")
+	flagSoundFont = flag.String("soundfont", "", "An SF2 file to render the soundfont waveform with")
+	flagTuning    = flag.String("tuning", defaultTuningName, "The tuning (12-tet, just, <n>-edo or a Scala .scl file)")
+	flagRefPitch  = flag.Float64("a4", defaultReferencePitch, "The reference frequency of A4 in Hz")
+	flagKBM       = flag.String("kbm", "", "A Scala .kbm keyboard mapping for the tuning")
+	flagPan       = flag.Float64("pan", 0, "The stereo position from -1 (left) to 1 (right) for tracks without a MIDI pan")
+	flagVibRate   = flag.Float64("vibrato-rate", defaultVibratoRate, "The vibrato rate in Hz")
+	flagVibDepth  = flag.Float64("vibrato-depth", 0, "The vibrato depth in cents (the modulation wheel adds to it)")
+	flagVibDelay  = flag.Float64("vibrato-delay", 0, "The seconds into each note before the vibrato starts")
+	flagGlide     = flag.Float64("glide", 0, "The portamento time in seconds between consecutive notes")
+	flagEffects   = flag.String("effects", "", "The effects chain (e.g. \"lowpass:cutoff=800;reverb:mix=0.4\") or a JSON preset file")
+	flagSaveFX    = flag.String("save-effects", "", "Save the -effects chain as a JSON preset file")
+	flagNormalize = flag.String("normalize", "", "Normalize the output to a peak or loudness target (peak or lufs)")
+	flagTarget    = flag.Float64("target", 0, "The normalization target in dBFS (peak) or LUFS (lufs), defaults to -1 dBFS or -14 LUFS")
+	flagLimit     = flag.Bool("limit", true, "Run the look-ahead limiter on the output")
+	flagCeiling   = flag.Float64("ceiling", defaultLimiterCeilingDBFS, "The limiter ceiling in dBFS")
+	flagDither    = flag.Bool("dither", true, "Add TPDF dither when quantizing the output")
+	flagClick     = flag.Bool("click", false, "Mix a metronome click through the motif")
+	flagCountIn   = flag.Int("count-in", 0, "The bars of metronome count-in before the motif starts")
+	flagClickStem = flag.Bool("click-stem", false, "Write the click to its own file instead of mixing it in")
+	flagQuantize  = flag.Int("quantize", 0, "The grid to quantize notes to as a division of a whole note (e.g. 16 for sixteenths)")
+	flagTriplets  = flag.Bool("triplets", false, "Quantize to a triplet grid")
+	flagStrength  = flag.Float64("strength", 1, "How far notes move towards the grid from 0 to 1")
+	flagSwing     = flag.Float64("swing", straightSwing, "The swing percent of the grid, 50 is straight and 66 a triplet shuffle")
+	flagMinRest   = flag.Int("min-rest", 0, "Close up rests shorter than this division of a whole note (e.g. 32)")
+	flagSegment   = flag.Bool("segment", false, "Cut each track into phrases at rests, long notes, repeats and bar lines")
+	flagCorpus    = flag.String("corpus", "", "The MIDI file or directory of MIDI files to search for the -input motif or for -variations to learn from")
+	flagMaxDist   = flag.Float64("max-distance", 1, "The edits to the motif's intervals a search match can have")
+	flagRhythm    = flag.Bool("rhythm", false, "Compare the rhythm of search matches as well as their intervals")
+	flagVariation = flag.Int("variations", 0, "The variations of the first melodic motif to generate and play after it")
+	flagSeed      = flag.Int64("seed", 1, "The random seed of -variations, the same seed generates the same variations")
+	flagOrnament  = flag.Float64("ornament", defaultOrnamentChance, "The chance from 0 to 1 of each note of a variation getting an ornament")
+	flagHarmonize = flag.String("harmonize", "", "Add a chord accompaniment to the first melodic motif voiced as block, arpeggio or alberti")
+	flagMIDI      = flag.Bool("midi", false, "Also write the rendered motifs to a MIDI file next to the output")
+	flagMusicXML  = flag.Bool("musicxml", false, "Also write the rendered motifs as a MusicXML score next to the output")
+	flagChordFmt  = flag.String("chord-format", "", "Write the chords of -mode chords to the output dir as json, musicxml or midi")
+	flagVerbose   = flag.Bool("verbose", false, "Log every note as it is parsed and rendered and the loudness of each render")
+	flagTransform = flag.String("transform", "", "The transform chain applied to the motifs (e.g. \"transpose:semitones=-3;retrograde\") or a JSON file")
+	outputDirs    = []string{"input", "output"}
 )
 
 func printReflectionInfo(t *midi.Track) {
@@ -143,14 +181,220 @@ func getCLIArgs() (string, string, string, string) {
 	return *flagInput, *flagOutput, *flagWaveForm, *flagFormat
 }
 
+// validate the mastering flags and fill in the default target for the normalization mode
+func getMasteringOptions(normalize string, target float64, limit bool, ceiling float64, dither bool) (MasteringOptions, error) {
+	opts := MasteringOptions{Normalize: strings.ToLower(normalize), Target: target, Limit: limit, Ceiling: ceiling, Dither: dither}
+	switch opts.Normalize {
+	case "", "none":
+		opts.Normalize = ""
+	case "peak":
+		if opts.Target == 0 {
+			opts.Target = defaultPeakTargetDBFS
+		}
+	case "lufs":
+		if opts.Target == 0 {
+			opts.Target = defaultLoudnessTargetLUFS
+		}
+	default:
+		return opts, fmt.Errorf("unknown normalization %v", normalize)
+	}
+	return opts, nil
+}
+
+// read the quantization, -segment, -transform, -variations and -harmonize flags or exit
+func getParseOptions() ParseOptions {
+	transforms, err := loadTransformChain(*flagTransform)
+	if err != nil {
+		fmt.Println("Provide a valid -transform flag:", err)
+		os.Exit(1)
+	}
+	if len(transforms) > 0 {
+		fmt.Println("Transforms:", formatTransformChain(transforms))
+	}
+	q := Quantization{Grid: *flagQuantize, Triplets: *flagTriplets, Strength: *flagStrength, Swing: *flagSwing, MinRest: *flagMinRest}
+	if err := q.validate(); err != nil {
+		fmt.Println("Provide valid quantization flags:", err)
+		os.Exit(1)
+	}
+	variations := VariationOptions{Count: *flagVariation, Seed: *flagSeed, Ornament: *flagOrnament}
+	if variations.Count > 0 && *flagCorpus != "" {
+		files, err := getCorpusFiles(*flagCorpus)
+		if err != nil {
+			fmt.Println("Provide a valid -corpus flag:", err)
+			os.Exit(1)
+		}
+		variations.Corpus = parseCorpusMotifs(files, ParseOptions{Quantize: q})
+	}
+	if _, ok := harmonyStyles[*flagHarmonize]; *flagHarmonize != "" && !ok {
+		fmt.Println("Provide a valid -harmonize flag")
+		os.Exit(1)
+	}
+	return ParseOptions{Quantize: q, Segment: *flagSegment, Transforms: transforms, Variations: variations, Harmony: *flagHarmonize}
+}
+
 func runCLIApp() {
-	inputFilePath, outputFile, wf, _ := getCLIArgs()
-	outputFilePath := "./output/" + outputFile + ".wav"
-	c := make(chan bool)
-	go convertMIDIFileToWAVFile(inputFilePath, outputFilePath, wf, c)
-	<-c
+	inputFilePath, outputFile, wf, format := getCLIArgs()
+	outputFilePath := "./output/" + outputFile + "." + format
+	parse := getParseOptions()
+	effects, err := loadEffectChain(*flagEffects)
+	if err != nil {
+		fmt.Println("Provide a valid -effects flag:", err)
+		os.Exit(1)
+	}
+	if *flagSaveFX != "" {
+		if err := saveEffectChain(*flagSaveFX, effects); err != nil {
+			fmt.Println("Error saving effects preset:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Effects preset saved at", *flagSaveFX)
+	}
+	if len(effects) > 0 {
+		fmt.Println("Effects:", formatEffectChain(effects))
+	}
+	mastering, err := getMasteringOptions(*flagNormalize, *flagTarget, *flagLimit, *flagCeiling, *flagDither)
+	if err != nil {
+		fmt.Println("Provide a valid -normalize flag:", err)
+		os.Exit(1)
+	}
+	opts := RenderOptions{
+		Format:          format,
+		WaveForm:        wf,
+		Tuning:          *flagTuning,
+		ReferencePitch:  *flagRefPitch,
+		KeyboardMapping: *flagKBM,
+		Pan:             *flagPan,
+		Vibrato:         Vibrato{Rate: *flagVibRate, Depth: *flagVibDepth, Delay: *flagVibDelay},
+		Glide:           *flagGlide,
+		Effects:         effects,
+		Mastering:       mastering,
+		Metronome:       Metronome{Click: *flagClick, CountIn: *flagCountIn, Stem: *flagClickStem},
+		Parse:           parse,
+		MIDI:            *flagMIDI,
+		MusicXML:        *flagMusicXML,
+	}
+	c := make(chan ConversionResult)
+	go convertMIDIFileToWAVFile(inputFilePath, outputFilePath, opts, c)
+	result := <-c
+	printMotifProblems(result.Problems)
 	go expireFile(inputFilePath)
 	go expireFile(outputFilePath)
+	if result.MIDIFile != "" {
+		go expireFile(result.MIDIFile)
+	}
+	if result.ScoreFile != "" {
+		go expireFile(result.ScoreFile)
+	}
+	if result.Manifest != nil {
+		manifestFilePath := getManifestPath(outputFilePath)
+		if err := saveStemManifest(result.Manifest, manifestFilePath, ""); err != nil {
+			fmt.Println("Error saving stem manifest:", err)
+		} else {
+			fmt.Println("Stem manifest saved at", manifestFilePath)
+		}
+		for _, stemFile := range append(result.Manifest.getFiles(), manifestFilePath) {
+			go expireFile(stemFile)
+		}
+	}
+}
+
+// print the motifs parsed from the input file as JSON, with their keys and computed fields
+func runInspectApp() {
+	inputFilePath, _, _, _ := getCLIArgs()
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, getParseOptions())
+	if err != nil {
+		fmt.Println("Error parsing MIDI file:", err)
+		printMotifProblems(getMotifProblems(err))
+		os.Exit(1)
+	}
+	jsonData, err := json.MarshalIndent(motifs, "", "    ")
+	if err != nil {
+		fmt.Println("Error encoding motifs:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(jsonData))
+}
+
+// print a table of melodic statistics for each motif of the input file
+func runAnalyzeApp() {
+	inputFilePath, _, _, _ := getCLIArgs()
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, getParseOptions())
+	if err != nil {
+		fmt.Println("Error parsing MIDI file:", err)
+		printMotifProblems(getMotifProblems(err))
+		os.Exit(1)
+	}
+	fmt.Println("")
+	if err := writeAnalysisTable(os.Stdout, analyzeMotifs(motifs)); err != nil {
+		fmt.Println("Error writing analysis:", err)
+		os.Exit(1)
+	}
+}
+
+// search the -corpus for the first melodic motif of the input file and print where it is heard
+func runSearchApp() {
+	inputFilePath, _, _, _ := getCLIArgs()
+	parse := getParseOptions()
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, parse)
+	if err != nil {
+		fmt.Println("Error parsing MIDI file:", err)
+		printMotifProblems(getMotifProblems(err))
+		os.Exit(1)
+	}
+	query, err := getQueryMotif(motifs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	files, err := getCorpusFiles(*flagCorpus)
+	if err != nil {
+		fmt.Println("Provide a valid -corpus flag:", err)
+		os.Exit(1)
+	}
+	// the transforms shape the query, the corpus is searched as it was written
+	opts := SearchOptions{MaxDistance: *flagMaxDist, Rhythm: *flagRhythm}
+	matches, err := searchMIDIFiles(query, files, opts, ParseOptions{Quantize: parse.Quantize})
+	if err != nil {
+		fmt.Println("Error searching:", err)
+		os.Exit(1)
+	}
+	fmt.Println("")
+	if err := writeSearchTable(os.Stdout, matches); err != nil {
+		fmt.Println("Error writing matches:", err)
+		os.Exit(1)
+	}
+}
+
+// print the chords heard across the tracks of the input file and export them in the -chord-format
+func runChordsApp() {
+	inputFilePath, outputFile, _, _ := getCLIArgs()
+	ext, ok := chordFormats[*flagChordFmt]
+	if *flagChordFmt != "" && !ok {
+		fmt.Println("Provide a valid -chord-format flag")
+		os.Exit(1)
+	}
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, getParseOptions())
+	if err != nil {
+		fmt.Println("Error parsing MIDI file:", err)
+		printMotifProblems(getMotifProblems(err))
+		os.Exit(1)
+	}
+	ct := detectChordTrack(motifs)
+	fmt.Println("")
+	if err := writeChordTable(os.Stdout, ct); err != nil {
+		fmt.Println("Error writing chords:", err)
+		os.Exit(1)
+	}
+	if *flagChordFmt == "" {
+		return
+	}
+	// ignore error if dir already exists
+	_ = os.Mkdir(outputFileDir, 0777)
+	outputFilePath := outputFileDir + outputFile + "." + ext
+	if err := saveChordTrack(ct, *flagChordFmt, outputFilePath); err != nil {
+		fmt.Println("Error saving chords:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Chords saved at", outputFilePath)
 }
 
 func main() {
@@ -162,11 +406,32 @@ func main() {
 	initMotivicConfig()
 	// get any CLI args
 	flag.Parse()
+	verbose = *flagVerbose
+
+	// load the SoundFont used by the soundfont waveform
+	if *flagSoundFont != "" {
+		if err := loadSoundFont(*flagSoundFont); err != nil {
+			fmt.Println("Error loading SoundFont:", err)
+			os.Exit(1)
+		}
+	}
 
 	// process CLI input
 	if *flagMode == "cli" {
 		fmt.Println("...running app in CLI mode")
 		runCLIApp()
+	} else if *flagMode == "inspect" {
+		fmt.Println("...running app in inspect mode")
+		runInspectApp()
+	} else if *flagMode == "analyze" {
+		fmt.Println("...running app in analyze mode")
+		runAnalyzeApp()
+	} else if *flagMode == "search" {
+		fmt.Println("...running app in search mode")
+		runSearchApp()
+	} else if *flagMode == "chords" {
+		fmt.Println("...running app in chords mode")
+		runChordsApp()
 	} else {
 		// spin up web server
 		fmt.Println("...running app in HTTP mode")
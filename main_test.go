@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// the note names and pitches come from config.json in the repo root
+func TestMain(m *testing.M) {
+	initMotivicConfig()
+	os.Exit(m.Run())
+}
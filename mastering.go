@@ -0,0 +1,352 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/go-audio/audio"
+)
+
+const defaultPeakTargetDBFS float64 = -1
+const defaultLoudnessTargetLUFS float64 = -14
+const defaultLimiterCeilingDBFS float64 = -1
+const limiterLookAheadSecs float64 = 0.005
+const limiterReleaseSecs float64 = 0.05
+
+// EBU R128 / ITU-R BS.1770 gating
+const loudnessBlockSecs float64 = 0.4
+const loudnessStepSecs float64 = 0.1
+const loudnessAbsoluteGateLUFS float64 = -70
+const loudnessRelativeGateLU float64 = -10
+
+// seed for the dither noise so renders are reproducible
+const ditherSeed int64 = 1
+
+// MasteringOptions : output stage applied to the mix before it is encoded
+type MasteringOptions struct {
+	Normalize string  // "", "peak" or "lufs"
+	Target    float64 // dBFS for peak normalization, LUFS for loudness normalization
+	Limit     bool    // run the look-ahead limiter
+	Ceiling   float64 // limiter ceiling in dBFS
+	Dither    bool    // add TPDF dither when quantizing to audioBitDepth
+}
+
+// LoudnessReport : loudness of the rendered audio before and after mastering
+type LoudnessReport struct {
+	InputLUFS      float64 `json:"inputLufs"`
+	InputPeakDBFS  float64 `json:"inputPeakDbfs"`
+	GainDB         float64 `json:"gainDb"` // gain applied by normalization
+	OutputLUFS     float64 `json:"outputLufs"`
+	OutputPeakDBFS float64 `json:"outputPeakDbfs"`
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+func gainToDB(gain float64) float64 {
+	if gain <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(gain)
+}
+
+// JSON can't encode infinities so silence is reported at this floor
+func clampDB(db float64) float64 {
+	return math.Max(db, -144)
+}
+
+// full scale sample value at audioBitDepth
+func getFullScale() float64 {
+	return float64(audio.IntMaxSignedValue(audioBitDepth))
+}
+
+// biquad used by the K-weighting filter
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// BS.1770 K-weighting (high shelf then high-pass) designed for any sample rate
+func newKWeightingFilters(sampleRate int) (*biquad, *biquad) {
+	fs := float64(sampleRate)
+	// stage 1: head related high shelf
+	k := math.Tan(math.Pi * 1681.974450955533 / fs)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	// stage 2: RLB high-pass
+	k = math.Tan(math.Pi * 38.13547087602444 / fs)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// LoudnessMeter : measures the integrated loudness and peak of audio fed to it a block at a time
+// only the energy of each gating step is kept so memory grows with duration by a few values a second
+type loudnessMeter struct {
+	numChannels   int
+	filters       [][2]*biquad // K-weighting shelf and high-pass per channel
+	stepFrames    int
+	blockSteps    int // a gating block is made of this many steps
+	steps         []float64
+	partial       float64 // energy of the step being filled
+	partialFrames int
+	total         float64
+	frames        int
+	peak          float64
+}
+
+func newLoudnessMeter(format *audio.Format) *loudnessMeter {
+	lm := &loudnessMeter{
+		numChannels: format.NumChannels,
+		stepFrames:  int(loudnessStepSecs * float64(format.SampleRate)),
+	}
+	lm.blockSteps = int(loudnessBlockSecs*float64(format.SampleRate)) / lm.stepFrames
+	for ch := 0; ch < format.NumChannels; ch++ {
+		shelf, highPass := newKWeightingFilters(format.SampleRate)
+		lm.filters = append(lm.filters, [2]*biquad{shelf, highPass})
+	}
+	return lm
+}
+
+// measure interleaved frames
+func (lm *loudnessMeter) add(data []float64) {
+	fullScale := getFullScale()
+	for i := 0; i+lm.numChannels <= len(data); i += lm.numChannels {
+		// K-weighted squared samples summed across channels (all weights are 1 for mono and stereo)
+		z := 0.0
+		for ch := 0; ch < lm.numChannels; ch++ {
+			lm.peak = math.Max(lm.peak, math.Abs(data[i+ch]))
+			f := lm.filters[ch]
+			y := f[1].process(f[0].process(data[i+ch] / fullScale))
+			z += y * y
+		}
+		lm.partial += z
+		lm.total += z
+		lm.partialFrames++
+		lm.frames++
+		if lm.partialFrames == lm.stepFrames {
+			lm.steps = append(lm.steps, lm.partial)
+			lm.partial, lm.partialFrames = 0, 0
+		}
+	}
+}
+
+// peak level of everything measured in dBFS
+func (lm *loudnessMeter) getPeakDBFS() float64 {
+	return gainToDB(lm.peak / getFullScale())
+}
+
+// integrated loudness of everything measured in LUFS (EBU R128)
+func (lm *loudnessMeter) getLoudness() float64 {
+	var blocks []float64
+	if lm.frames > 0 && len(lm.steps) < lm.blockSteps {
+		// short renders still get measured as one block
+		blocks = append(blocks, lm.total/float64(lm.frames))
+	}
+	blockFrames := float64(lm.blockSteps * lm.stepFrames)
+	for start := 0; start+lm.blockSteps <= len(lm.steps); start++ {
+		sum := 0.0
+		for _, v := range lm.steps[start : start+lm.blockSteps] {
+			sum += v
+		}
+		blocks = append(blocks, sum/blockFrames)
+	}
+	blockLoudness := func(z float64) float64 {
+		return -0.691 + 10*math.Log10(z)
+	}
+	gatedMean := func(threshold float64) (float64, int) {
+		sum, count := 0.0, 0
+		for _, z := range blocks {
+			if z > 0 && blockLoudness(z) > threshold {
+				sum += z
+				count++
+			}
+		}
+		if count == 0 {
+			return 0, 0
+		}
+		return sum / float64(count), count
+	}
+	mean, count := gatedMean(loudnessAbsoluteGateLUFS)
+	if count == 0 {
+		return math.Inf(-1)
+	}
+	mean, count = gatedMean(blockLoudness(mean) + loudnessRelativeGateLU)
+	if count == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(mean)
+}
+
+// Limiter : look-ahead peak limiter
+// the gain reduction needed by each frame is spread over the look-ahead window
+// before it so peaks are caught without clipping or clicks
+// frames come out of the limiter delayed by the look-ahead so it can run over a stream
+type limiter struct {
+	numChannels int
+	lookAhead   int
+	ceiling     float64
+	releaseCoef float64
+	delay       []float64 // ring of the frames waiting for the look-ahead to fill
+	targets     []float64 // ring of the gain each delayed frame needs to sit under the ceiling
+	minima      []int     // ring of the frames that can still set the window minimum, their targets rising from the head
+	minHead     int
+	minCount    int
+	pushed      int       // frames taken in so far
+	released    int       // frames let go so far
+	held        []float64 // ring of held gains averaged into the output gain
+	heldPos     int
+	heldSum     float64
+	prev        float64
+	out         []float64
+}
+
+func newLimiter(format *audio.Format, ceilingDBFS float64) *limiter {
+	lookAhead := int(limiterLookAheadSecs*float64(format.SampleRate)) + 1
+	l := &limiter{
+		numChannels: format.NumChannels,
+		lookAhead:   lookAhead,
+		ceiling:     dbToGain(ceilingDBFS) * getFullScale(),
+		releaseCoef: 1 - math.Exp(-1/(limiterReleaseSecs*float64(format.SampleRate))),
+		delay:       make([]float64, lookAhead*format.NumChannels),
+		targets:     make([]float64, lookAhead),
+		minima:      make([]int, lookAhead),
+		held:        make([]float64, lookAhead),
+		heldSum:     float64(lookAhead),
+		prev:        1,
+	}
+	// frames before the start count as needing no reduction
+	for i := range l.held {
+		l.held[i] = 1
+	}
+	return l
+}
+
+// push interleaved frames in and return the frames that have cleared the look-ahead
+// the returned slice is reused by the next call
+func (l *limiter) process(data []float64) []float64 {
+	out := l.out[:0]
+	for i := 0; i+l.numChannels <= len(data); i += l.numChannels {
+		slot := l.pushed % l.lookAhead
+		peak := 0.0
+		for ch, v := range data[i : i+l.numChannels] {
+			l.delay[slot*l.numChannels+ch] = v
+			peak = math.Max(peak, math.Abs(v))
+		}
+		target := 1.0
+		if peak > l.ceiling {
+			target = l.ceiling / peak
+		}
+		l.targets[slot] = target
+		// frames needing less reduction than this one can't be the minimum while it is in the window
+		for l.minCount > 0 && l.targets[l.minima[(l.minHead+l.minCount-1)%l.lookAhead]%l.lookAhead] >= target {
+			l.minCount--
+		}
+		l.minima[(l.minHead+l.minCount)%l.lookAhead] = l.pushed
+		l.minCount++
+		l.pushed++
+		if l.pushed-l.released == l.lookAhead {
+			out = l.release(out)
+		}
+	}
+	l.out = out
+	return out
+}
+
+// return the frames still held back by the look-ahead at the end of the stream
+func (l *limiter) flush() []float64 {
+	out := l.out[:0]
+	for l.released < l.pushed {
+		out = l.release(out)
+	}
+	l.out = out
+	return out
+}
+
+// apply the gain to the oldest delayed frame and append it to out
+func (l *limiter) release(out []float64) []float64 {
+	// drop the frames that have left the window so its minimum is at the head
+	for l.minima[l.minHead] < l.released {
+		l.minHead = (l.minHead + 1) % l.lookAhead
+		l.minCount--
+	}
+	slot := l.released % l.lookAhead
+	// minimum over the look-ahead window with a release when the gain recovers
+	m := l.targets[l.minima[l.minHead]%l.lookAhead]
+	if m < l.prev {
+		l.prev = m
+	} else {
+		l.prev += (m - l.prev) * l.releaseCoef
+	}
+	// average over the window so gain changes ramp instead of stepping,
+	// every frame averaged covers this frame so the result never exceeds its target
+	l.heldSum += l.prev - l.held[l.heldPos]
+	l.held[l.heldPos] = l.prev
+	l.heldPos = (l.heldPos + 1) % l.lookAhead
+	gain := math.Min(l.heldSum/float64(l.lookAhead), l.targets[slot])
+	for _, v := range l.delay[slot*l.numChannels : (slot+1)*l.numChannels] {
+		out = append(out, v*gain)
+	}
+	l.released++
+	return out
+}
+
+// gain in dB that normalization applies given the measured peak and loudness
+func getNormalizationGain(opts MasteringOptions, peakDBFS float64, loudness float64) float64 {
+	switch opts.Normalize {
+	case "peak":
+		if !math.IsInf(peakDBFS, -1) {
+			return opts.Target - peakDBFS
+		}
+	case "lufs":
+		if !math.IsInf(loudness, -1) {
+			return opts.Target - loudness
+		}
+	}
+	return 0
+}
+
+// take a float buffer at PCM scale and return it quantized to audioBitDepth
+// with optional TPDF dither, clipping anything beyond full scale
+func quantizeBuffer(buf *audio.FloatBuffer, dither bool, rng *rand.Rand) *audio.IntBuffer {
+	max := audio.IntMaxSignedValue(audioBitDepth)
+	data := make([]int, len(buf.Data))
+	for i, v := range buf.Data {
+		if dither {
+			// triangular noise of +/- 1 LSB is the sum of two uniform variables
+			v += rng.Float64() - rng.Float64()
+		}
+		s := int(math.Round(v))
+		if s > max {
+			s = max
+		} else if s < -max-1 {
+			s = -max - 1
+		}
+		data[i] = s
+	}
+	return &audio.IntBuffer{Data: data, Format: buf.Format, SourceBitDepth: audioBitDepth}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/go-audio/audio"
+)
+
+// interleaved frames of a sine at a peak level in dBFS on every channel
+func testSine(format *audio.Format, freq, peakDBFS, secs float64) []float64 {
+	amp := dbToGain(peakDBFS) * getFullScale()
+	frames := int(secs * float64(format.SampleRate))
+	data := make([]float64, 0, frames*format.NumChannels)
+	for i := 0; i < frames; i++ {
+		v := amp * math.Sin(2*math.Pi*freq*float64(i)/float64(format.SampleRate))
+		for ch := 0; ch < format.NumChannels; ch++ {
+			data = append(data, v)
+		}
+	}
+	return data
+}
+
+func TestGetLoudness(t *testing.T) {
+	tests := []struct {
+		format   *audio.Format
+		peakDBFS float64
+		secs     float64
+		want     float64
+	}{
+		// EBU Tech 3341: a stereo 1 kHz sine at -23 dBFS reads -23 LUFS
+		{audio.FormatStereo44100, -23, 20, -23},
+		{audio.FormatStereo48000, -23, 20, -23},
+		{audio.FormatStereo44100, -33, 20, -33},
+		{audio.FormatStereo44100, -3, 5, -3},
+		// one channel has half the energy
+		{audio.FormatMono44100, -20, 5, -23.01},
+		// renders shorter than a gating block are measured as one block
+		{audio.FormatStereo44100, -23, 0.2, -23},
+		// anything under the absolute gate is silence
+		{audio.FormatStereo44100, -75, 5, math.Inf(-1)},
+	}
+	for _, tt := range tests {
+		lm := newLoudnessMeter(tt.format)
+		lm.add(testSine(tt.format, 1000, tt.peakDBFS, tt.secs))
+		got := lm.getLoudness()
+		if math.IsInf(tt.want, -1) {
+			if !math.IsInf(got, -1) {
+				t.Errorf("%d channels at %v dBFS = %v LUFS, want silence", tt.format.NumChannels, tt.peakDBFS, got)
+			}
+			continue
+		}
+		if math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%d channels at %d Hz %v dBFS for %vs = %v LUFS, want %v", tt.format.NumChannels, tt.format.SampleRate, tt.peakDBFS, tt.secs, got, tt.want)
+		}
+		if peak := lm.getPeakDBFS(); math.Abs(peak-tt.peakDBFS) > 0.01 {
+			t.Errorf("%d channels at %v dBFS peak = %v dBFS", tt.format.NumChannels, tt.peakDBFS, peak)
+		}
+	}
+}
+
+func TestLimiterCeiling(t *testing.T) {
+	format := audio.FormatStereo44100
+	rng := rand.New(rand.NewSource(1))
+	noise := make([]float64, 44100*format.NumChannels)
+	for i := range noise {
+		noise[i] = (rng.Float64()*2 - 1) * 4 * getFullScale()
+	}
+	// isolated clicks well over the ceiling between quiet passages
+	clicks := testSine(format, 440, -30, 1)
+	for i := 1000; i < len(clicks); i += 7919 {
+		clicks[i] = 3 * getFullScale()
+	}
+	tests := []struct {
+		name        string
+		data        []float64
+		ceilingDBFS float64
+	}{
+		{"sine 12 dB over", testSine(format, 1000, 0, 1), -12},
+		{"low sine 6 dB over", testSine(format, 50, -1, 1), -7},
+		{"noise", noise, -1},
+		{"clicks", clicks, -0.3},
+		{"quiet sine", testSine(format, 1000, -20, 1), -1},
+	}
+	for _, tt := range tests {
+		l := newLimiter(format, tt.ceilingDBFS)
+		ceiling := dbToGain(tt.ceilingDBFS) * getFullScale()
+		var out []float64
+		// feed odd sized blocks as a stream would
+		for start := 0; start < len(tt.data); start += 1001 * format.NumChannels {
+			end := start + 1001*format.NumChannels
+			if end > len(tt.data) {
+				end = len(tt.data)
+			}
+			out = append(out, l.process(tt.data[start:end])...)
+		}
+		out = append(out, l.flush()...)
+		if len(out) != len(tt.data) {
+			t.Errorf("%v: got %d samples out, want %d", tt.name, len(out), len(tt.data))
+			continue
+		}
+		for i, v := range out {
+			if math.Abs(v) > ceiling*(1+1e-9) {
+				t.Errorf("%v: sample %d is %v dBFS, over the %v dBFS ceiling", tt.name, i, gainToDB(math.Abs(v)/getFullScale()), tt.ceilingDBFS)
+				break
+			}
+		}
+	}
+	// audio under the ceiling comes out untouched
+	quiet := testSine(format, 1000, -20, 1)
+	l := newLimiter(format, -1)
+	out := append(append([]float64{}, l.process(quiet)...), l.flush()...)
+	for i := range quiet {
+		if out[i] != quiet[i] {
+			t.Fatalf("quiet sample %d = %v, want %v", i, out[i], quiet[i])
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// General MIDI percussion keys of the metronome
+const midiMetronomeClick int = 33
+const midiMetronomeBell int = 34
+
+// level of the click under the motif
+const clickGain float64 = 0.5
+
+// suffix of the click stem written next to the rendered audio
+const clickStemSuffix string = "_click"
+
+// Metronome : click track rendered with a motif for practising along to it
+type Metronome struct {
+	Click   bool // click through the whole motif
+	CountIn int  // bars of clicks before the motif starts
+	Stem    bool // render the click to its own file instead of mixing it in
+}
+
+func (mt Metronome) isEnabled() bool {
+	return mt.Click || mt.CountIn > 0
+}
+
+// units of motif time in a beat of the tempo and in a bar
+func getUnitsPerBeat(ts TimeSignature) int {
+	return ts.Beat * ts.Unit
+}
+
+func getUnitsPerBar(ts TimeSignature) int {
+	return ts.Beat * getUnitsPerBeat(ts)
+}
+
+// take motif and return a copy delayed by bars of rest for the count-in
+func addCountIn(m Motif, bars int) Motif {
+	if bars <= 0 {
+		return m
+	}
+	return delayMotif(m, bars*getUnitsPerBar(m.TimeSignature))
+}
+
+// take motif and return a copy starting after units of rest
+func delayMotif(m Motif, units int) Motif {
+	if units <= 0 {
+		return m
+	}
+	notes := []MotifNote{{Note: newNote(-1, units), StartingBeat: 1}}
+	for _, n := range m.Notes {
+		n.StartingBeat += units
+		notes = append(notes, n)
+	}
+	shift := func(points []ControlPoint) []ControlPoint {
+		var shifted []ControlPoint
+		for _, p := range points {
+			shifted = append(shifted, ControlPoint{Beat: p.Beat + units, Value: p.Value})
+		}
+		return shifted
+	}
+	m.Notes = notes
+	m.PitchBend = shift(m.PitchBend)
+	m.Modulation = shift(m.Modulation)
+	m.computeRelativeFields()
+	return m
+}
+
+// take motifs (already delayed by their count-in) and return a percussion motif
+// clicking their beats with a bell on every downbeat
+func getClickMotif(motifs []Motif, mt Metronome) Motif {
+	m := motifs[0]
+	unitsPerBeat := getUnitsPerBeat(m.TimeSignature)
+	unitsPerBar := getUnitsPerBar(m.TimeSignature)
+	end := mt.CountIn * unitsPerBar
+	if mt.Click {
+		for _, motif := range motifs {
+			for _, n := range motif.Notes {
+				if last := n.StartingBeat - 1 + n.Duration; last > end {
+					end = last
+				}
+			}
+		}
+	}
+	click := Motif{ID: "click", Name: "click", Tempo: m.Tempo, TimeSignature: m.TimeSignature, Percussion: true}
+	for u := 0; u < end; u += unitsPerBeat {
+		key := midiMetronomeClick
+		if u%unitsPerBar == 0 {
+			key = midiMetronomeBell
+		}
+		click.Notes = append(click.Notes, MotifNote{Note: newNote(key+midiNoteValueOffset, unitsPerBeat), StartingBeat: u + 1})
+	}
+	click.computeRelativeFields()
+	return click
+}
+
+// click tracks are rendered in the centre whatever the motifs are rendered with, in the mix they go through its effects like any track
+func getClickTrack(motifs []Motif, mt Metronome) RenderTrack {
+	return RenderTrack{Motif: getClickMotif(motifs, mt), Gain: clickGain}
+}
+
+// take motifs and return them delayed by their count-in
+func addCountIns(motifs []Motif, bars int) []Motif {
+	var delayed []Motif
+	for _, m := range motifs {
+		delayed = append(delayed, addCountIn(m, bars))
+	}
+	return delayed
+}
+
+// take motifs and return the tracks mixed into their output
+func getRenderTracks(motifs []Motif, opts RenderOptions) []RenderTrack {
+	var tracks []RenderTrack
+	var placed []Motif
+	for _, m := range motifs {
+		placed = append(placed, placeMotif(joinTiedNotes(m)))
+	}
+	motifs = addCountIns(placed, opts.Metronome.CountIn)
+	for _, m := range motifs {
+		tracks = append(tracks, RenderTrack{Motif: m, Options: opts, Gain: 1})
+	}
+	if opts.Metronome.isEnabled() && !opts.Metronome.Stem {
+		tracks = append(tracks, getClickTrack(motifs, opts.Metronome))
+	}
+	return tracks
+}
+
+// path of the click stem written alongside an output file
+func getClickStemPath(outputFilePath string) string {
+	ext := filepath.Ext(outputFilePath)
+	return strings.TrimSuffix(outputFilePath, ext) + clickStemSuffix + ext
+}
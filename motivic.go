@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 // Pitch : scientific notation pitch
@@ -42,6 +43,12 @@ type MotifNote struct {
 	Steps        int `json:"steps"`        // relative to Motif.Notes[0].Value
 	StartingBeat int `json:"startingBeat"` // relative to Motif.Notes[0].StartingBeat
 	Interval     int `json:"interval"`     // relative to Motif.Key
+	// how the note is written, a note held over a bar line or for a length no single value has
+	// is split into pieces tied together
+	Type   string  `json:"type,omitempty"` // written value (quarter), empty when it can't be written
+	Dots   int     `json:"dots,omitempty"`
+	Tuplet *Tuplet `json:"tuplet,omitempty"`
+	Tie    string  `json:"tie,omitempty"` // start, continue or stop
 }
 
 // Tempo : Motivic.Tempo class
@@ -62,16 +69,34 @@ type Motif struct {
 	Name string `json:"name"`
 	Key  string `json:"key"`
 	Mode string `json:"mode"`
+	// how sure we are of the key, 1 when it comes from a key signature
+	KeyConfidence float64 `json:"keyConfidence"`
+	// midi for a key signature, detected for a key estimated from the notes
+	KeySource string `json:"keySource,omitempty"`
+	// MIDI program (instrument) selected by the track's program change
+	Program int `json:"program"`
+	// bank the program is picked from, selected by the bank select controllers before the program change
+	Bank int `json:"bank,omitempty"`
+	// notes are General MIDI percussion keys rather than pitches
+	Percussion bool `json:"percussion"`
+	// stereo position from -1 (left) to 1 (right), nil when the MIDI track has no pan
+	Pan *float64 `json:"pan,omitempty"`
+	// continuous controllers: pitch bend in semitones and modulation wheel from 0 to 1
+	PitchBend  []ControlPoint `json:"pitchBend,omitempty"`
+	Modulation []ControlPoint `json:"modulation,omitempty"`
+	// units into its track that a motif cut from a longer one starts
+	Offset int `json:"offset,omitempty"`
 	Tempo
 	TimeSignature
-	Notes []MotifNote
+	Notes []MotifNote `json:"notes"`
 }
 
 // MotivicConfig : Motivic music theory config
 type MotivicConfig struct {
-	Frequencies [][]float64 `json:"frequencies"`
-	Notes       []string    `json:"notes"`
-	Pitches     []Pitch
+	Notes   []string `json:"notes"`
+	Octaves int      `json:"octaves"`
+	// computed
+	Pitches []Pitch
 }
 
 var config MotivicConfig
@@ -86,28 +111,42 @@ func Index(vs []string, t string) int {
 	return -1
 }
 
+// take a note or key name (c, f#, bb) and return its pitch class (0-11), c when unknown
+func getPitchClass(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return 0
+	}
+	idx := Index(config.Notes, name[:1])
+	if idx < 0 {
+		return 0
+	}
+	for _, accidental := range name[1:] {
+		switch accidental {
+		case '#':
+			idx++
+		case 'b':
+			idx--
+		}
+	}
+	return (idx + 12) % 12
+}
+
 func getNoteNameAndOctave(value int) (string, int) {
-	// notes with negative value are rests
-	if value < 0 {
+	// notes with negative value are rests, values off the pitch table are left for Validate to report
+	if value < 1 || value > len(config.Pitches) {
 		return "", -1
 	}
 	note := config.Pitches[value-1]
 	return note.Name, note.Octave
 }
 
-func getPitchFrequency(pitch string, octave int) float64 {
-	// handle rests - where pitch and octave are falsey
-	if pitch == "" {
-		return 0.00
-	}
-	idx := Index(config.Notes, pitch)
-	return config.Frequencies[octave][idx]
-}
+// list the pitches of every octave, frequencies come from the tuning a motif is rendered with
 func (c *MotivicConfig) setPitches() {
 	var pitches []Pitch
-	for octIdx := range c.Frequencies {
+	for octIdx := 0; octIdx < c.Octaves; octIdx++ {
 		for noteIdx, n := range c.Notes {
-			p := Pitch{Name: n, Octave: octIdx, Value: (octIdx * 12) + noteIdx + 1}
+			p := Pitch{Name: n, Octave: octIdx, Value: (octIdx * len(c.Notes)) + noteIdx + 1}
 			pitches = append(pitches, p)
 		}
 	}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// the middle C note value, motifs mostly below it are written in the bass clef
+const middleCValue int = 49
+
+// musicXMLScore : a MusicXML partwise score
+type musicXMLScore struct {
+	XMLName  xml.Name            `xml:"score-partwise"`
+	Version  string              `xml:"version,attr"`
+	PartList []musicXMLScorePart `xml:"part-list>score-part"`
+	Parts    []musicXMLPart      `xml:"part"`
+}
+
+type musicXMLScorePart struct {
+	ID       string `xml:"id,attr"`
+	PartName string `xml:"part-name"`
+}
+
+type musicXMLPart struct {
+	ID       string            `xml:"id,attr"`
+	Measures []musicXMLMeasure `xml:"measure"`
+}
+
+type musicXMLMeasure struct {
+	Number   int           `xml:"number,attr"`
+	Elements []interface{} // attributes, directions, harmonies, notes, backups and forwards in the order they are read
+}
+
+type musicXMLAttributes struct {
+	XMLName   xml.Name `xml:"attributes"`
+	Divisions int      `xml:"divisions"`
+	Fifths    int      `xml:"key>fifths"`
+	Mode      string   `xml:"key>mode,omitempty"`
+	Beats     int      `xml:"time>beats"`
+	BeatType  int      `xml:"time>beat-type"`
+	ClefSign  string   `xml:"clef>sign"`
+	ClefLine  int      `xml:"clef>line"`
+}
+
+type musicXMLDirection struct {
+	XMLName   xml.Name `xml:"direction"`
+	Placement string   `xml:"placement,attr"`
+	Metronome struct {
+		BeatUnit  string `xml:"beat-unit"`
+		PerMinute int    `xml:"per-minute"`
+	} `xml:"direction-type>metronome"`
+	Sound struct {
+		Tempo int `xml:"tempo,attr"`
+	} `xml:"sound"`
+}
+
+type musicXMLHarmony struct {
+	XMLName   xml.Name `xml:"harmony"`
+	RootStep  string   `xml:"root>root-step"`
+	RootAlter int      `xml:"root>root-alter,omitempty"`
+	Kind      struct {
+		Text  string `xml:"text,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"kind"`
+	Inversion int           `xml:"inversion,omitempty"`
+	Bass      *musicXMLBass // only for slash chords
+}
+
+type musicXMLBass struct {
+	XMLName xml.Name `xml:"bass"`
+	Step    string   `xml:"bass-step"`
+	Alter   int      `xml:"bass-alter,omitempty"`
+}
+
+type musicXMLNote struct {
+	XMLName          xml.Name  `xml:"note"`
+	Chord            *struct{} `xml:"chord"`
+	Pitch            *musicXMLPitch
+	Rest             *struct{}     `xml:"rest"`
+	Duration         int           `xml:"duration"`
+	Ties             []musicXMLTie `xml:"tie"`
+	Voice            int           `xml:"voice,omitempty"`
+	Type             string        `xml:"type,omitempty"`
+	Dots             []struct{}    `xml:"dot"`
+	TimeModification *musicXMLTimeModification
+	Notations        *musicXMLNotations // only for tied notes
+}
+
+type musicXMLNotations struct {
+	XMLName xml.Name      `xml:"notations"`
+	Tied    []musicXMLTie `xml:"tied"` // the ties as they are drawn
+}
+
+type musicXMLPitch struct {
+	XMLName xml.Name `xml:"pitch"`
+	Step    string   `xml:"step"`
+	Alter   int      `xml:"alter,omitempty"`
+	Octave  int      `xml:"octave"`
+}
+
+type musicXMLTie struct {
+	Type string `xml:"type,attr"`
+}
+
+type musicXMLTimeModification struct {
+	XMLName     xml.Name `xml:"time-modification"`
+	ActualNotes int      `xml:"actual-notes"`
+	NormalNotes int      `xml:"normal-notes"`
+}
+
+type musicXMLBackup struct {
+	XMLName  xml.Name `xml:"backup"`
+	Duration int      `xml:"duration"`
+}
+
+type musicXMLForward struct {
+	XMLName  xml.Name `xml:"forward"`
+	Duration int      `xml:"duration"`
+	Voice    int      `xml:"voice,omitempty"`
+}
+
+// step and alter of a note name (C#)
+func getMusicXMLStep(name string) (string, int) {
+	alter := strings.Count(name, "#") - strings.Count(name, "b")
+	return strings.ToUpper(name[:1]), alter
+}
+
+// the attributes of the first measure of a part, its divisions are units per quarter note
+func getMusicXMLAttributes(ts TimeSignature, key string, mode string) musicXMLAttributes {
+	attributes := musicXMLAttributes{
+		Divisions: getUnitsPerWholeNote(ts) / 4,
+		Beats:     ts.Beat,
+		BeatType:  ts.Unit,
+		ClefSign:  "G",
+		ClefLine:  2,
+	}
+	if key != "" {
+		attributes.Fifths = getKeyFifths(key, mode)
+		attributes.Mode = strings.ToLower(mode)
+	}
+	return attributes
+}
+
+func getMusicXMLTempo(t Tempo) musicXMLDirection {
+	tempo := musicXMLDirection{Placement: "above"}
+	tempo.Metronome.BeatUnit = "quarter"
+	tempo.Metronome.PerMinute = t.Units
+	tempo.Sound.Tempo = t.Units
+	return tempo
+}
+
+// a note or rest of a written value, its ties are added by the caller
+func newMusicXMLNote(value int, v writtenValue) musicXMLNote {
+	note := musicXMLNote{Duration: v.duration, Type: v.name, Dots: make([]struct{}, v.dots)}
+	if value < 0 {
+		note.Rest = &struct{}{}
+	} else {
+		name, octave := getNoteNameAndOctave(value)
+		note.Pitch = &musicXMLPitch{Octave: octave}
+		note.Pitch.Step, note.Pitch.Alter = getMusicXMLStep(name)
+	}
+	if v.tuplet != nil {
+		note.TimeModification = &musicXMLTimeModification{ActualNotes: v.tuplet.Actual, NormalNotes: v.tuplet.Normal}
+	}
+	return note
+}
+
+// tie a note to the note before it, after it or both
+func (note *musicXMLNote) tie(stop bool, start bool) {
+	if stop {
+		note.Ties = append(note.Ties, musicXMLTie{Type: "stop"})
+	}
+	if start {
+		note.Ties = append(note.Ties, musicXMLTie{Type: "start"})
+	}
+	if len(note.Ties) > 0 {
+		note.Notations = &musicXMLNotations{Tied: note.Ties}
+	}
+}
+
+func encodeMusicXMLScore(score musicXMLScore, w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE score-partwise PUBLIC "-//Recordare//DTD MusicXML 4.0 Partwise//EN" "http://www.musicxml.org/dtds/partwise.dtd">`+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "    ")
+	return encoder.Encode(score)
+}
+
+// musicXMLVoiceNote : a written note placed in a voice of its part
+type musicXMLVoiceNote struct {
+	MotifNote
+	position int // units from the start of the track
+	voice    int
+	chord    bool // sounds with the note before it in its voice
+}
+
+// share the written notes of a motif between voices, notes starting and stopping together are a chord,
+// the pieces of a tied note stay in the voice they started in and rests only go in the first voice
+func getMusicXMLVoices(m Motif) ([]musicXMLVoiceNote, int) {
+	var placed []musicXMLVoiceNote
+	var last []int // the last note placed in each voice
+	ties := map[[2]int]int{}
+	ends := func(v int) int {
+		n := placed[last[v]]
+		return n.position + n.Duration
+	}
+	for _, n := range m.Notes {
+		p := musicXMLVoiceNote{MotifNote: n, position: m.Offset + n.StartingBeat - 1, voice: -1}
+		if v, ok := ties[[2]int{n.Value, n.StartingBeat}]; ok && (n.Tie == tieContinue || n.Tie == tieStop) {
+			delete(ties, [2]int{n.Value, n.StartingBeat})
+			p.voice = v
+		}
+		if p.voice < 0 && n.Value >= 0 {
+			for v := range last {
+				prev := placed[last[v]]
+				if prev.Value >= 0 && prev.position == p.position && prev.Duration == n.Duration && prev.Type == n.Type {
+					p.voice = v
+					break
+				}
+			}
+		}
+		if p.voice < 0 {
+			for v := range last {
+				if n.Value < 0 && v > 0 {
+					break
+				}
+				if ends(v) <= p.position {
+					p.voice = v
+					break
+				}
+			}
+		}
+		if p.voice < 0 && len(last) == 0 {
+			p.voice = 0
+		}
+		if p.voice < 0 {
+			// rests under notes that are still sounding aren't written
+			if n.Value < 0 {
+				continue
+			}
+			p.voice = len(last)
+		}
+		if p.voice == len(last) {
+			last = append(last, -1)
+		} else if prev := placed[last[p.voice]]; n.Value >= 0 && prev.Value >= 0 && prev.position == p.position {
+			p.chord = true
+		}
+		if n.Tie == tieStart || n.Tie == tieContinue {
+			ties[[2]int{n.Value, n.StartingBeat + n.Duration}] = p.voice
+		}
+		last[p.voice] = len(placed)
+		placed = append(placed, p)
+	}
+	return placed, len(last)
+}
+
+// take a written motif and return it as the measures of a part, each voice of a measure
+// after the one before it, with rests filling the first voice and the others moving on over their gaps
+func getMusicXMLMeasures(m Motif, withTempo bool) []musicXMLMeasure {
+	ts := m.TimeSignature
+	unitsPerBar := getUnitsPerBar(ts)
+	placed, voices := getMusicXMLVoices(m)
+	end := 0
+	for _, p := range placed {
+		end = maxInt(end, p.position+p.Duration)
+	}
+	bars := maxInt(1, (end+unitsPerBar-1)/unitsPerBar)
+
+	attributes := getMusicXMLAttributes(ts, m.Key, m.Mode)
+	if m.Percussion {
+		attributes.ClefSign = "percussion"
+	} else if getMotifAverageValue(m) < middleCValue {
+		attributes.ClefSign, attributes.ClefLine = "F", 4
+	}
+	var measures []musicXMLMeasure
+	for bar := 0; bar < bars; bar++ {
+		measure := musicXMLMeasure{Number: bar + 1}
+		if bar == 0 {
+			measure.Elements = append(measure.Elements, attributes)
+			if withTempo {
+				measure.Elements = append(measure.Elements, getMusicXMLTempo(m.Tempo))
+			}
+		}
+		from, to := bar*unitsPerBar, (bar+1)*unitsPerBar
+		for voice := 0; voice < maxInt(1, voices); voice++ {
+			if voice > 0 {
+				measure.Elements = append(measure.Elements, musicXMLBackup{Duration: unitsPerBar})
+			}
+			position := from
+			gap := func(until int) {
+				if until <= position {
+					return
+				}
+				if voice > 0 {
+					measure.Elements = append(measure.Elements, musicXMLForward{Duration: until - position, Voice: voice + 1})
+				} else {
+					for _, v := range getWrittenValues(position, until, ts) {
+						rest := newMusicXMLNote(-1, v)
+						rest.Voice = 1
+						measure.Elements = append(measure.Elements, rest)
+					}
+				}
+				position = until
+			}
+			for _, p := range placed {
+				if p.voice != voice || p.position < from || p.position >= to {
+					continue
+				}
+				if !p.chord {
+					gap(p.position)
+				}
+				v := writtenValue{start: p.position, duration: p.Duration, name: p.Type, dots: p.Dots, tuplet: p.Tuplet}
+				note := newMusicXMLNote(p.Value, v)
+				note.Voice = voice + 1
+				if p.chord {
+					note.Chord = &struct{}{}
+				}
+				note.tie(p.Tie == tieContinue || p.Tie == tieStop, p.Tie == tieStart || p.Tie == tieContinue)
+				measure.Elements = append(measure.Elements, note)
+				if !p.chord {
+					position = p.position + p.Duration
+				}
+			}
+			gap(to)
+		}
+		measures = append(measures, measure)
+	}
+	return measures
+}
+
+// the average value of the sounding notes of a motif, 0 when it has none
+func getMotifAverageValue(m Motif) int {
+	total, count := 0, 0
+	for _, n := range m.Notes {
+		if n.Value >= 0 {
+			total += n.Value
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / count
+}
+
+// take written motifs and write them as a MusicXML score with a part for each
+func encodeMotifsMusicXML(motifs []Motif, w io.Writer) error {
+	score := musicXMLScore{Version: "4.0"}
+	for idx, m := range motifs {
+		id := fmt.Sprintf("P%d", idx+1)
+		name := m.Name
+		if name == "" {
+			name = m.ID
+		}
+		score.PartList = append(score.PartList, musicXMLScorePart{ID: id, PartName: name})
+		score.Parts = append(score.Parts, musicXMLPart{ID: id, Measures: getMusicXMLMeasures(m, idx == 0)})
+	}
+	return encodeMusicXMLScore(score, w)
+}
+
+// take motifs and write them as a MusicXML score
+func saveMotifsMusicXMLFile(motifs []Motif, filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return encodeMotifsMusicXML(notateMotifs(motifs), file)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// written note values from the whole note down, as the divisions of a whole note they are
+var noteTypes = []struct {
+	Name     string
+	Division int
+}{
+	{"whole", 1},
+	{"half", 2},
+	{"quarter", 4},
+	{"eighth", 8},
+	{"16th", 16},
+	{"32nd", 32},
+	{"64th", 64},
+}
+
+// most dots a written value is given, a double dotted value is seven eighths of the next one up
+const maxNoteDots int = 2
+
+// ties between the written pieces of a note
+const (
+	tieStart    string = "start"
+	tieContinue string = "continue"
+	tieStop     string = "stop"
+)
+
+// Tuplet : notes written in the time of fewer of the same value, 3 in the time of 2 for triplets
+type Tuplet struct {
+	Actual int `json:"actual"`
+	Normal int `json:"normal"`
+}
+
+// writtenValue : a stretch of a track written as a single note value
+type writtenValue struct {
+	start    int // units from the start of the track
+	duration int
+	name     string // empty when the stretch can't be written in whole units and is left as it is
+	dots     int
+	tuplet   *Tuplet
+}
+
+func getUnitsPerWholeNote(ts TimeSignature) int {
+	return getUnitsPerBeat(ts) * ts.Unit
+}
+
+// the name of the note value of a division of a whole note
+func getNoteTypeName(division int) string {
+	for _, t := range noteTypes {
+		if t.Division == division {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// whether a position falls on a straight grid of 32nd notes, triplets fall between its lines
+func isBinaryPosition(units int, ts TimeSignature) bool {
+	return units*32%getUnitsPerWholeNote(ts) == 0
+}
+
+// the written value of a stretch that is one or two steps of a triplet, three notes in the time of two,
+// over a whole, half, quarter, eighth or 16th note, false when it isn't
+// the units of a triplet step are rounded so its lines are found rather than worked out from the stretch's length
+func getTripletValue(from int, to int, ts TimeSignature) (string, bool) {
+	if isBinaryPosition(from, ts) && isBinaryPosition(to, ts) {
+		return "", false
+	}
+	unitsPerWholeNote := getUnitsPerWholeNote(ts)
+	for _, t := range noteTypes[:5] {
+		span := unitsPerWholeNote / t.Division
+		if span*t.Division != unitsPerWholeNote || span < 3 {
+			continue
+		}
+		group := floorDiv(from, span) * span
+		first, last := -1, -1
+		for k := 0; k <= 3; k++ {
+			line := group + int(math.Round(float64(k*span)/3))
+			if line == from {
+				first = k
+			}
+			if line == to {
+				last = k
+			}
+		}
+		if first < 0 || last <= first || last-first > 2 {
+			continue
+		}
+		// a step is written as half the span and two steps as the span
+		if name := getNoteTypeName(t.Division * 2 / (last - first)); name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// units of a dotted note value, false when they aren't whole units
+func getDottedValueUnits(division int, dots int, ts TimeSignature) (int, bool) {
+	// each dot adds half the value before it, so n dots make (2^(n+1) - 1) / 2^n of the value
+	numerator := getUnitsPerWholeNote(ts) * (1<<uint(dots+1) - 1)
+	denominator := division * (1 << uint(dots))
+	if numerator%denominator != 0 {
+		return 0, false
+	}
+	return numerator / denominator, true
+}
+
+// the longest dotted note value that fits in a number of units
+func getLongestValue(units int, ts TimeSignature) (writtenValue, bool) {
+	for _, t := range noteTypes {
+		for dots := maxNoteDots; dots >= 0; dots-- {
+			if d, ok := getDottedValueUnits(t.Division, dots, ts); ok && d <= units {
+				return writtenValue{duration: d, name: t.Name, dots: dots}, true
+			}
+		}
+	}
+	return writtenValue{}, false
+}
+
+// split a stretch of a track into the note values it is written as, cut at bar lines,
+// with triplets where it starts or stops between the lines of a straight grid
+// and otherwise the longest dotted values that fit, off the beat a value only runs to the next beat
+func getWrittenValues(from int, to int, ts TimeSignature) []writtenValue {
+	unitsPerBar := getUnitsPerBar(ts)
+	unitsPerBeat := getUnitsPerBeat(ts)
+	var values []writtenValue
+	for from < to {
+		barEnd := minInt(to, (floorDiv(from, unitsPerBar)+1)*unitsPerBar)
+		values = append(values, getBarValues(from, barEnd, unitsPerBeat, ts)...)
+		from = barEnd
+	}
+	return values
+}
+
+// the written values of a stretch inside a bar
+func getBarValues(from int, to int, unitsPerBeat int, ts TimeSignature) []writtenValue {
+	triplet := func(a int, b int) (writtenValue, bool) {
+		name, ok := getTripletValue(a, b, ts)
+		return writtenValue{start: a, duration: b - a, name: name, tuplet: &Tuplet{3, 2}}, ok
+	}
+	if v, ok := triplet(from, to); ok {
+		return []writtenValue{v}
+	}
+	var head, tail []writtenValue
+	// a stretch starting inside a triplet takes the longest run of its steps
+	for from < to && !isBinaryPosition(from, ts) {
+		found := false
+		for end := to; end > from; end-- {
+			if v, ok := triplet(from, end); ok {
+				head = append(head, v)
+				from, found = end, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	// and one stopping inside a triplet ends with the longest run that ends it
+	if !isBinaryPosition(to, ts) {
+		for start := from; start < to; start++ {
+			if v, ok := triplet(start, to); ok {
+				tail = append(tail, v)
+				to = start
+				break
+			}
+		}
+	}
+	values := head
+	for from < to {
+		limit := to
+		if from%unitsPerBeat != 0 {
+			limit = minInt(to, (from/unitsPerBeat+1)*unitsPerBeat)
+		}
+		v, ok := getLongestValue(limit-from, ts)
+		if !ok {
+			// too short to write in whole units so it is left as it is
+			v = writtenValue{duration: limit - from}
+		}
+		v.start = from
+		values = append(values, v)
+		from += v.duration
+	}
+	return append(values, tail...)
+}
+
+// take a motif of sounding notes and return it written, each note and rest split at bar lines
+// and into the note values it is written as, with the pieces of a note tied together
+// notes are split where they sit in their track so a motif with an offset is cut at the track's bar lines
+func notateMotif(m Motif) Motif {
+	m = joinTiedNotes(m)
+	ts := m.TimeSignature
+	if ts.Beat <= 0 || ts.Unit <= 0 {
+		return m
+	}
+	var notes []MotifNote
+	for _, n := range m.Notes {
+		from := m.Offset + n.StartingBeat - 1
+		values := getWrittenValues(from, from+n.Duration, ts)
+		for idx, v := range values {
+			piece := n
+			piece.Duration = v.duration
+			piece.StartingBeat = v.start - m.Offset + 1
+			piece.Type, piece.Dots, piece.Tuplet = v.name, v.dots, v.tuplet
+			// rests aren't tied, the pieces of a rest are rests of their own
+			if n.Value >= 0 && len(values) > 1 {
+				switch idx {
+				case 0:
+					piece.Tie = tieStart
+				case len(values) - 1:
+					piece.Tie = tieStop
+				default:
+					piece.Tie = tieContinue
+				}
+			}
+			notes = append(notes, piece)
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].StartingBeat < notes[j].StartingBeat
+	})
+	m.Notes = notes
+	return m
+}
+
+func notateMotifs(motifs []Motif) []Motif {
+	var notated []Motif
+	for _, m := range motifs {
+		notated = append(notated, notateMotif(m))
+	}
+	return notated
+}
+
+// take a written motif and return the notes that sound, tied notes and rests back to back joined into one
+func joinTiedNotes(m Motif) Motif {
+	var notes []MotifNote
+	// value and end of each note waiting for the next piece of its tie
+	open := map[[2]int]int{}
+	for _, n := range m.Notes {
+		if n.Tie == tieContinue || n.Tie == tieStop {
+			if idx, ok := open[[2]int{n.Value, n.StartingBeat}]; ok {
+				delete(open, [2]int{n.Value, n.StartingBeat})
+				notes[idx].Duration += n.Duration
+				if n.Tie == tieContinue {
+					open[[2]int{n.Value, n.StartingBeat + n.Duration}] = idx
+				}
+				continue
+			}
+		}
+		if last := len(notes) - 1; n.Value < 0 && last >= 0 && notes[last].Value < 0 && notes[last].StartingBeat+notes[last].Duration == n.StartingBeat {
+			notes[last].Duration += n.Duration
+			continue
+		}
+		if n.Tie == tieStart || n.Tie == tieContinue {
+			open[[2]int{n.Value, n.StartingBeat + n.Duration}] = len(notes)
+		}
+		n.Type, n.Dots, n.Tuplet, n.Tie = "", 0, nil, ""
+		notes = append(notes, n)
+	}
+	m.Notes = notes
+	return m
+}
+
+func joinMotifsTiedNotes(motifs []Motif) []Motif {
+	var joined []Motif
+	for _, m := range motifs {
+		joined = append(joined, joinTiedNotes(m))
+	}
+	return joined
+}
+
+// where a written note starts and stops in thirds of a unit from the start of its track,
+// exact for triplets whose steps the units had to round
+func getWrittenNoteThirds(n MotifNote, offset int, ts TimeSignature) (int, int) {
+	start := (offset + n.StartingBeat - 1) * 3
+	end := start + n.Duration*3
+	if n.Tuplet == nil || n.Type == "" {
+		return start, end
+	}
+	for _, t := range noteTypes {
+		if t.Name != n.Type {
+			continue
+		}
+		written := getUnitsPerWholeNote(ts) / t.Division
+		// the lines of a tuplet fall on multiples of its written value over its number of notes
+		step := written * 3 / n.Tuplet.Actual
+		start = int(math.Round(float64(start)/float64(step))) * step
+		end = start + written*n.Tuplet.Normal*3/n.Tuplet.Actual
+	}
+	return start, end
+}
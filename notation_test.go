@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// a written note as its value, a dot for each dot, a 3 for a triplet and its tie
+func describeWrittenNote(n MotifNote) string {
+	s := n.Type + strings.Repeat(".", n.Dots)
+	if n.Tuplet != nil {
+		s += "3"
+	}
+	if n.Value < 0 {
+		s += " rest"
+	}
+	if n.Tie != "" {
+		s += " " + n.Tie
+	}
+	return s
+}
+
+func TestNotateMotifRoundTrip(t *testing.T) {
+	chord := testMotif("", []int{49, -1}, []int{80, 16})
+	chord.Notes = []MotifNote{chord.Notes[0], {Note: newNote(53, 32), StartingBeat: 49}, chord.Notes[1]}
+	offset := testMotif("", []int{49, 51}, []int{64, 16})
+	offset.Offset = 16
+	// 12 units a beat and 36 a bar
+	waltz := testMotif("", []int{49, 51}, []int{48, 24})
+	waltz.TimeSignature = TimeSignature{Beat: 3, Unit: 4}
+	tests := []struct {
+		name    string
+		m       Motif
+		written []string
+	}{
+		{"quarters", testMotif("", []int{49, 51, 53, 54}, []int{16, 16, 16, 16}), []string{"quarter", "quarter", "quarter", "quarter"}},
+		{
+			"held over the bar line",
+			testMotif("", []int{49, 51, 53, 54}, []int{24, 8, 48, 16}),
+			[]string{"quarter.", "eighth", "half start", "quarter stop", "quarter"},
+		},
+		{
+			"rest over the bar line",
+			testMotif("", []int{49, -1, 51}, []int{48, 32, 16}),
+			[]string{"half.", "quarter rest", "quarter rest", "quarter"},
+		},
+		{
+			"eighth note triplets",
+			testMotif("", []int{49, 51, 53, 54}, []int{5, 6, 5, 16}),
+			[]string{"eighth3", "eighth3", "eighth3", "quarter"},
+		},
+		{
+			"tied over two bar lines",
+			testMotif("", []int{49, 51}, []int{160, 32}),
+			[]string{"whole start", "whole continue", "half stop", "half"},
+		},
+		// an off beat note runs to the next beat before it takes a longer value
+		{"off the beat", testMotif("", []int{-1, 49, 51}, []int{8, 40, 16}), []string{"eighth rest", "eighth start", "half stop", "quarter"}},
+		{
+			"chord held over the bar line",
+			chord,
+			[]string{"whole start", "quarter start", "quarter stop", "quarter stop", "quarter rest"},
+		},
+		// a motif cut from later in its track is split at the track's bar lines
+		{"offset", offset, []string{"half. start", "quarter stop", "quarter"}},
+		{"three four", waltz, []string{"half. start", "quarter stop", "half"}},
+	}
+	for _, tt := range tests {
+		notated := notateMotif(tt.m)
+		var written []string
+		for _, n := range notated.Notes {
+			written = append(written, describeWrittenNote(n))
+		}
+		if !reflect.DeepEqual(written, tt.written) {
+			t.Errorf("%v: written as %v, want %v", tt.name, written, tt.written)
+		}
+		// writing a written motif again changes nothing
+		if again := notateMotif(notated); !reflect.DeepEqual(again, notated) {
+			t.Errorf("%v: notating twice gave %+v, want %+v", tt.name, again.Notes, notated.Notes)
+		}
+		// and joining its ties gives back the notes it was written from
+		if joined := joinTiedNotes(notated); !reflect.DeepEqual(joined, tt.m) {
+			t.Errorf("%v: joined back to %+v, want %+v", tt.name, joined.Notes, tt.m.Notes)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/generator"
+	"github.com/go-audio/midi"
+)
+
+// General MIDI default pitch bend sensitivity in semitones, files change it with registered parameter 0
+const midiPitchBendRange float64 = 2
+const midiPitchBendCenter float64 = 8192
+const midiModulationController uint8 = 1
+
+// controllers that select a registered or non-registered parameter and set its value
+const midiRPNMSBController uint8 = 101
+const midiRPNLSBController uint8 = 100
+const midiNRPNMSBController uint8 = 99
+const midiNRPNLSBController uint8 = 98
+const midiDataEntryMSBController uint8 = 6
+const midiDataEntryLSBController uint8 = 38
+
+// vibrato depth added by the modulation wheel at full travel
+const modulationVibratoCents float64 = 50
+const defaultVibratoRate float64 = 5.5
+const vibratoFadeInSecs float64 = 0.2
+
+// ramp used where a note starts or stops against silence
+const declickSecs float64 = 0.005
+
+// ControlPoint : value of a continuous controller from a beat onwards
+type ControlPoint struct {
+	Beat  int     `json:"beat"` // same units as MotifNote.StartingBeat
+	Value float64 `json:"value"`
+}
+
+// Vibrato : periodic pitch modulation applied to every note
+type Vibrato struct {
+	Rate  float64 // Hz
+	Depth float64 // cents
+	Delay float64 // seconds into the note before the vibrato fades in
+}
+
+// collect pitch bend (in semitones) and modulation wheel (0-1) curves from a track
+// bends are scaled by the pitch bend sensitivity the track sets with registered parameter 0
+func parseMIDIControlCurves(track *midi.Track, tb midiTimebase) ([]ControlPoint, []ControlPoint) {
+	var bends, modulation []ControlPoint
+	bendRange := midiPitchBendRange
+	// parameter the data entry controllers write to, none until one is selected
+	var rpnMSB, rpnLSB uint8 = 127, 127
+	for _, e := range track.Events {
+		beat := tb.getUnits(int(e.AbsTicks)) + 1
+		switch e.MsgType {
+		case midi.EventByteMap["PitchWheelChange"]:
+			semitones := (float64(e.AbsPitchBend) - midiPitchBendCenter) / midiPitchBendCenter * bendRange
+			bends = append(bends, ControlPoint{Beat: beat, Value: semitones})
+		case midi.EventByteMap["ControlChange"]:
+			switch e.Controller {
+			case midiModulationController:
+				modulation = append(modulation, ControlPoint{Beat: beat, Value: float64(e.NewValue) / 127})
+			case midiRPNMSBController:
+				rpnMSB = e.NewValue
+			case midiRPNLSBController:
+				rpnLSB = e.NewValue
+			case midiNRPNMSBController, midiNRPNLSBController:
+				rpnMSB, rpnLSB = 127, 127
+			case midiDataEntryMSBController:
+				if rpnMSB == 0 && rpnLSB == 0 {
+					bendRange = float64(e.NewValue)
+				}
+			case midiDataEntryLSBController:
+				if rpnMSB == 0 && rpnLSB == 0 {
+					bendRange = math.Floor(bendRange) + float64(e.NewValue)/100
+				}
+			}
+		}
+	}
+	return bends, modulation
+}
+
+// value of a controller at a beat, controllers hold their last value
+func getControlValue(points []ControlPoint, beat float64) float64 {
+	idx := sort.Search(len(points), func(i int) bool {
+		return float64(points[i].Beat) > beat
+	})
+	if idx == 0 {
+		return 0
+	}
+	return points[idx-1].Value
+}
+
+// oscillator waveform at a phase in [0, 2π)
+func getOscillatorSample(wf generator.WaveType, phase float64) float64 {
+	// the generator waveforms expect a phase from -π to π
+	x := phase - math.Pi
+	switch wf {
+	case generator.WaveTriangle:
+		return generator.Triangle(x)
+	case generator.WaveSaw:
+		return generator.Sawtooth(x)
+	case generator.WaveSqr:
+		return generator.Square(x)
+	default:
+		return math.Sin(phase)
+	}
+}
+
+// ContinuousOscillator : phase continuous oscillators that render a motif note by note
+// so consecutive notes can glide, follow pitch bends and carry vibrato without discontinuities
+// each sounding note has a voice of its own so overlapping notes and chords keep their own phase
+type continuousOscillator struct {
+	motif       Motif
+	opts        RenderOptions
+	tuning      Tuning
+	wf          generator.WaveType
+	vibratoRate float64
+	legato      []bool // whether a note runs straight into a note that starts where it ends
+	voices      []oscillatorVoice
+}
+
+// OscillatorVoice : phase and pitch of one line of a motif carried from note to note
+type oscillatorVoice struct {
+	phase float64
+	freq  float64 // pitch of the last note, 0 once the voice has gone silent
+	end   int
+}
+
+func newContinuousOscillator(m Motif, opts RenderOptions, tuning Tuning) *continuousOscillator {
+	wf := waveForm[opts.WaveForm]
+	if wf == 0 {
+		wf = defaultWaveForm
+	}
+	vibratoRate := opts.Vibrato.Rate
+	if vibratoRate <= 0 {
+		vibratoRate = defaultVibratoRate
+	}
+	return &continuousOscillator{motif: m, opts: opts, tuning: tuning, wf: wf, vibratoRate: vibratoRate, legato: getLegatoNotes(m)}
+}
+
+// pair notes that end where another starts so each one is carried on by at most one note
+// notes are paired in order, a chord running into a single note only carries on its first note
+func getLegatoNotes(m Motif) []bool {
+	starts := map[int]int{}
+	for _, n := range m.Notes {
+		if start, end := getNoteSpan(n, m); n.Value >= 0 && end > start {
+			starts[start]++
+		}
+	}
+	legato := make([]bool, len(m.Notes))
+	for idx, n := range m.Notes {
+		if start, end := getNoteSpan(n, m); n.Value >= 0 && end > start && starts[end] > 0 {
+			legato[idx] = true
+			starts[end]--
+		}
+	}
+	return legato
+}
+
+// voice for a note starting at start, a voice a legato note ended on carries on into it
+// otherwise a voice that has finished its last note is reused or a new one allocated
+func (o *continuousOscillator) getVoice(start int) *oscillatorVoice {
+	for i := range o.voices {
+		if v := &o.voices[i]; v.freq > 0 && v.end == start {
+			return v
+		}
+	}
+	for i := range o.voices {
+		if v := &o.voices[i]; v.end <= start {
+			v.freq = 0
+			return v
+		}
+	}
+	o.voices = append(o.voices, oscillatorVoice{})
+	return &o.voices[len(o.voices)-1]
+}
+
+// render the note at idx to be placed at its start on the timeline, rests return nil
+// voices carry their phase from note to note so notes have to be rendered in order
+func (o *continuousOscillator) renderNote(idx int) []float64 {
+	m, opts := o.motif, o.opts
+	n := m.Notes[idx]
+	factor := float64(audio.IntMaxSignedValue(audioBitDepth))
+	sampleRate := float64(audioSampleRate)
+	unitSecs := getDurationInSeconds(1, m.Tempo, m.TimeSignature)
+	rampSamples := int(math.Ceil(declickSecs * sampleRate))
+
+	start, end := getNoteSpan(n, m)
+	length := end - start
+	freq := o.tuning.getFrequency(getMIDINote(n.Value))
+	if n.Value < 0 || freq == 0 || length <= 0 {
+		return nil
+	}
+	v := o.getVoice(start)
+	prevFreq := v.freq
+	// only ramp the edges that touch silence, legato joins stay continuous
+	rampIn := prevFreq == 0
+	rampOut := !o.legato[idx]
+	data := make([]float64, length)
+	for j := range data {
+		t := float64(j) / sampleRate
+		base := freq
+		if opts.Glide > 0 && prevFreq > 0 && t < opts.Glide {
+			// exponential glide sounds linear in pitch
+			base = prevFreq * math.Pow(freq/prevFreq, t/opts.Glide)
+		}
+		beat := float64(n.StartingBeat) + t/unitSecs
+		semitones := getControlValue(m.PitchBend, beat)
+		depth := opts.Vibrato.Depth + getControlValue(m.Modulation, beat)*modulationVibratoCents
+		if depth > 0 && t > opts.Vibrato.Delay {
+			vt := t - opts.Vibrato.Delay
+			fadeIn := math.Min(1, vt/vibratoFadeInSecs)
+			semitones += depth * fadeIn * math.Sin(2*math.Pi*o.vibratoRate*vt) / 100
+		}
+		v.phase = math.Mod(v.phase+2*math.Pi*base*math.Pow(2, semitones/12)/sampleRate, 2*math.Pi)
+		amp := 1.0
+		if rampIn && j < rampSamples {
+			amp = float64(j) / float64(rampSamples)
+		}
+		if rampOut && length-j < rampSamples {
+			amp = math.Min(amp, float64(length-j)/float64(rampSamples))
+		}
+		data[j] = getOscillatorSample(o.wf, v.phase) * amp * factor
+	}
+	v.end = end
+	v.freq = freq
+	if rampOut {
+		// the voice has ramped down so the next note on it starts from silence
+		v.freq = 0
+	}
+	return data
+}
+
+// whether a motif would bend, wobble or glide between pitches when rendered
+func hasPitchModulation(m Motif, opts RenderOptions) bool {
+	return opts.Vibrato.Depth > 0 || opts.Glide > 0 || len(m.PitchBend) > 0 || len(m.Modulation) > 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-audio/midi"
+)
+
+// ticks per quarter note assumed for files that time their events in SMPTE frames
+const midiDefaultTicksPerBeat int = 128
+
+// quarter notes in a whole note, grids and rests are given as divisions of a whole note
+const beatsPerWholeNote int = 4
+
+// swing percent of a straight rhythm
+const straightSwing float64 = 50
+
+// ParseOptions : settings for turning a MIDI file into motifs
+type ParseOptions struct {
+	Quantize   Quantization
+	Segment    bool             // cut each track into its phrases
+	Transforms []TransformSpec  // applied to the motifs once they are parsed
+	Variations VariationOptions // generated from the first melodic motif once it is transformed
+	Harmony    string           // voicing style of an accompaniment generated for the first melodic motif, empty for none
+}
+
+// Quantization : snapping of played note timings to a rhythmic grid
+type Quantization struct {
+	Grid     int     // divisions of a whole note (16 for sixteenths), 0 to keep the timings as played
+	Triplets bool    // fit three steps of the grid in the time of two
+	Strength float64 // how far notes move towards the grid from 0 to 1
+	Swing    float64 // percent of a pair of grid steps before the offbeat, 50 is straight and 66 a triplet shuffle
+	MinRest  int     // rests shorter than this division of a whole note are closed up by the note before, 0 keeps them all
+}
+
+// check the quantization can be applied, a grid of 0 leaves the timings as played
+func (q Quantization) validate() error {
+	switch {
+	case q.Grid < 0:
+		return fmt.Errorf("quantize grid %v isn't a division of a whole note", q.Grid)
+	case q.Strength < 0 || q.Strength > 1:
+		return fmt.Errorf("quantize strength %v isn't from 0 to 1", q.Strength)
+	case q.Swing < 0 || q.Swing >= 100:
+		return fmt.Errorf("swing %v isn't a percent below 100", q.Swing)
+	case q.MinRest < 0:
+		return fmt.Errorf("minimum rest %v isn't a division of a whole note", q.MinRest)
+	}
+	return nil
+}
+
+// midiTimebase : converts the ticks of a MIDI file to motif units
+type midiTimebase struct {
+	ticksPerBeat int // ticks per quarter note of the file
+	unitsPerBeat int // units per quarter note of the motif
+}
+
+func newMIDITimebase(ticksPerBeat int, ts TimeSignature) midiTimebase {
+	if ticksPerBeat <= 0 {
+		ticksPerBeat = midiDefaultTicksPerBeat
+	}
+	return midiTimebase{ticksPerBeat: ticksPerBeat, unitsPerBeat: getUnitsPerBeat(ts)}
+}
+
+// units from the start of the file to a tick
+func (tb midiTimebase) getUnits(ticks int) int {
+	return int(math.Round(float64(ticks) * float64(tb.unitsPerBeat) / float64(tb.ticksPerBeat)))
+}
+
+// ticks in a division of a whole note
+func (tb midiTimebase) getDivisionTicks(division int) float64 {
+	return float64(tb.ticksPerBeat*beatsPerWholeNote) / float64(division)
+}
+
+// nearest line of a swung grid to a tick, every second line is pushed late by the swing
+func (q Quantization) getGridTicks(ticks float64, step float64) float64 {
+	swing := q.Swing
+	if swing <= 0 {
+		swing = straightSwing
+	}
+	pair := math.Floor(ticks/(2*step)) * 2 * step
+	best := pair
+	for _, line := range []float64{pair + 2*step*swing/100, pair + 2*step} {
+		if math.Abs(line-ticks) < math.Abs(best-ticks) {
+			best = line
+		}
+	}
+	return best
+}
+
+// take the notes of a track and return them moved towards a grid with short rests closed up
+func quantizeMIDIEvents(events []*midi.AbsEv, tb midiTimebase, q Quantization) []*midi.AbsEv {
+	if q.Grid <= 0 && q.MinRest <= 0 {
+		return events
+	}
+	quantized := make([]*midi.AbsEv, 0, len(events))
+	for _, e := range events {
+		c := *e
+		quantized = append(quantized, &c)
+	}
+	if q.Grid > 0 {
+		step := tb.getDivisionTicks(q.Grid)
+		if q.Triplets {
+			step = step * 2 / 3
+		}
+		strength := math.Max(0, math.Min(1, q.Strength))
+		snap := func(ticks int) float64 {
+			t := float64(ticks)
+			return t + (q.getGridTicks(t, step)-t)*strength
+		}
+		for _, e := range quantized {
+			start, end := snap(e.Start), snap(e.Start+e.Duration)
+			// notes shorter than the grid would snap to nothing so they hold for a step instead
+			if end <= start {
+				end = start + step
+			}
+			e.Start = int(math.Round(start))
+			e.Duration = int(math.Round(end)) - e.Start
+		}
+		sort.SliceStable(quantized, func(i, j int) bool {
+			return quantized[i].Start < quantized[j].Start
+		})
+	}
+	if q.MinRest > 0 {
+		minRest := int(math.Round(tb.getDivisionTicks(q.MinRest)))
+		// the note sounding longest so far is held over gaps too short to be rests
+		var last *midi.AbsEv
+		for _, e := range quantized {
+			if last != nil {
+				if gap := e.Start - last.End(); gap > 0 && gap < minRest {
+					last.Duration += gap
+				}
+			}
+			if last == nil || e.End() > last.End() {
+				last = e
+			}
+		}
+	}
+	return quantized
+}
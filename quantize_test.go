@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-audio/midi"
+)
+
+func TestGetGridTicks(t *testing.T) {
+	// eighth note steps at 96 ticks a quarter note
+	step := 48.0
+	tests := []struct {
+		swing float64
+		ticks float64
+		want  float64
+	}{
+		{0, 0, 0},
+		{0, 20, 0},
+		{0, 30, 48},
+		{50, 50, 48},
+		{50, 90, 96},
+		{50, 200, 192},
+		// a triplet shuffle pushes the offbeat to two thirds of the pair
+		{66, 30, 0},
+		{66, 35, 63.36},
+		{66, 60, 63.36},
+		{66, 79, 63.36},
+		{66, 82, 96},
+		{66, 160, 159.36},
+		// swing can pull the offbeat early too
+		{25, 20, 24},
+		{25, 55, 24},
+		{25, 70, 96},
+	}
+	for _, tt := range tests {
+		q := Quantization{Grid: 8, Swing: tt.swing}
+		if got := q.getGridTicks(tt.ticks, step); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("swing %v tick %v = %v, want %v", tt.swing, tt.ticks, got, tt.want)
+		}
+	}
+}
+
+func TestQuantizeMIDIEvents(t *testing.T) {
+	type note struct{ key, start, duration int }
+	tests := []struct {
+		name   string
+		q      Quantization
+		events []note
+		want   []note
+	}{
+		{
+			"sixteenths",
+			Quantization{Grid: 16, Strength: 1},
+			[]note{{60, 22, 50}, {62, 100, 5}},
+			// the short note would snap to nothing so it holds for a step
+			[]note{{60, 24, 48}, {62, 96, 24}},
+		},
+		{
+			"half strength",
+			Quantization{Grid: 16, Strength: 0.5},
+			[]note{{60, 12, 36}, {62, 52, 40}},
+			[]note{{60, 6, 42}, {62, 50, 44}},
+		},
+		{
+			"eighth note triplets",
+			Quantization{Grid: 8, Triplets: true, Strength: 1},
+			[]note{{60, 30, 35}, {62, 70, 20}, {64, 130, 60}},
+			[]note{{60, 32, 32}, {62, 64, 32}, {64, 128, 64}},
+		},
+		{
+			"swung eighths",
+			Quantization{Grid: 8, Strength: 1, Swing: 66},
+			[]note{{60, 0, 40}, {62, 50, 40}},
+			[]note{{60, 0, 63}, {62, 63, 33}},
+		},
+		{
+			"notes reordered by the grid",
+			Quantization{Grid: 16, Strength: 1},
+			[]note{{60, 13, 20}, {62, 10, 30}},
+			[]note{{62, 0, 48}, {60, 24, 24}},
+		},
+		{
+			"short rests closed up",
+			Quantization{MinRest: 16},
+			[]note{{60, 0, 40}, {62, 50, 40}, {64, 200, 10}},
+			[]note{{60, 0, 50}, {62, 50, 40}, {64, 200, 10}},
+		},
+		{
+			"rests closed up by the longest note",
+			Quantization{MinRest: 8},
+			[]note{{48, 0, 96}, {60, 0, 20}, {62, 110, 40}},
+			[]note{{48, 0, 110}, {60, 0, 20}, {62, 110, 40}},
+		},
+		{
+			"as played",
+			Quantization{},
+			[]note{{60, 13, 20}, {62, 10, 30}},
+			[]note{{60, 13, 20}, {62, 10, 30}},
+		},
+	}
+	tb := newMIDITimebase(96, TimeSignature{Beat: 4, Unit: 4})
+	for _, tt := range tests {
+		var events []*midi.AbsEv
+		for _, n := range tt.events {
+			events = append(events, &midi.AbsEv{MIDINote: n.key, Start: n.start, Duration: n.duration})
+		}
+		got := quantizeMIDIEvents(events, tb, tt.q)
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %d events, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if e := got[i]; e.MIDINote != want.key || e.Start != want.start || e.Duration != want.duration {
+				t.Errorf("%v: event %d = %v at %d for %d, want %+v", tt.name, i, e.MIDINote, e.Start, e.Duration, want)
+			}
+		}
+		// the events parsed from the file are left as they were
+		for i, n := range tt.events {
+			if events[i].Start != n.start || events[i].Duration != n.duration {
+				t.Errorf("%v: input event %d changed to %d for %d", tt.name, i, events[i].Start, events[i].Duration)
+			}
+		}
+	}
+}
+
+func TestQuantizationValidate(t *testing.T) {
+	tests := []struct {
+		q   Quantization
+		err bool
+	}{
+		{Quantization{}, false},
+		{Quantization{Grid: 16, Triplets: true, Strength: 1, Swing: 66, MinRest: 32}, false},
+		{Quantization{Grid: -8}, true},
+		{Quantization{Grid: 16, Strength: 1.5}, true},
+		{Quantization{Grid: 16, Strength: -0.1}, true},
+		{Quantization{Grid: 16, Swing: 100}, true},
+		{Quantization{Grid: 16, Swing: -10}, true},
+		{Quantization{MinRest: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.q.validate(); (err != nil) != tt.err {
+			t.Errorf("%+v: got error %v, want error %v", tt.q, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultModeName string = "major"
+
+// semitones above the tonic of the notes of each mode
+var modeIntervals = map[string][]int{
+	"major":            {0, 2, 4, 5, 7, 9, 11},
+	"ionian":           {0, 2, 4, 5, 7, 9, 11},
+	"dorian":           {0, 2, 3, 5, 7, 9, 10},
+	"phrygian":         {0, 1, 3, 5, 7, 8, 10},
+	"lydian":           {0, 2, 4, 6, 7, 9, 11},
+	"mixolydian":       {0, 2, 4, 5, 7, 9, 10},
+	"minor":            {0, 2, 3, 5, 7, 8, 10},
+	"aeolian":          {0, 2, 3, 5, 7, 8, 10},
+	"locrian":          {0, 1, 3, 5, 6, 8, 10},
+	"harmonic minor":   {0, 2, 3, 5, 7, 8, 11},
+	"melodic minor":    {0, 2, 3, 5, 7, 9, 11},
+	"major pentatonic": {0, 2, 4, 7, 9},
+	"minor pentatonic": {0, 3, 5, 7, 10},
+	"blues":            {0, 3, 5, 6, 7, 10},
+	"chromatic":        {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+}
+
+// Scale : the tonic of a key and the notes its mode picks
+type Scale struct {
+	Tonic     int    // pitch class 0-11
+	Mode      string // name of the mode in modeIntervals
+	Intervals []int  // semitones above the tonic
+}
+
+// take a key (c, f#, bb) and mode name and return its scale
+func newScale(key string, mode string) (Scale, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = defaultModeName
+	}
+	intervals, ok := modeIntervals[mode]
+	if !ok {
+		return Scale{}, fmt.Errorf("unknown mode %v", mode)
+	}
+	return Scale{Tonic: getPitchClass(key), Mode: mode, Intervals: intervals}, nil
+}
+
+// pitch class (0-11) of a note value, value 1 is c0
+func getValuePitchClass(value int) int {
+	return floorMod(value-1, 12)
+}
+
+// semitones a note sits above the tonic (0-11)
+func (s Scale) getInterval(value int) int {
+	return floorMod(getValuePitchClass(value)-s.Tonic, 12)
+}
+
+// position of a note in the scale (0 for the tonic) and whether the scale contains it
+func (s Scale) getDegree(value int) (int, bool) {
+	interval := s.getInterval(value)
+	for idx, i := range s.Intervals {
+		if i == interval {
+			return idx, true
+		}
+	}
+	return -1, false
+}
+
+// the closest note of the scale to a note, searching up (direction > 0), down (direction < 0) or both
+// notes in the scale stay where they are and ties go down
+func (s Scale) snap(value int, direction int) int {
+	for d := 0; d < 12; d++ {
+		if _, ok := s.getDegree(value - d); ok && direction <= 0 {
+			return value - d
+		}
+		if _, ok := s.getDegree(value + d); ok && direction >= 0 {
+			return value + d
+		}
+	}
+	return value
+}
+
+// position of a note counted in steps of the scale from the tonic of octave 0,
+// notes outside the scale take the degree below them and return how many semitones above it they are
+func (s Scale) getScalePosition(value int) (int, int) {
+	interval := s.getInterval(value)
+	idx := 0
+	for i, si := range s.Intervals {
+		if si <= interval {
+			idx = i
+		}
+	}
+	octave := floorDiv(value-1-s.Tonic, 12)
+	return octave*len(s.Intervals) + idx, interval - s.Intervals[idx]
+}
+
+// note value at a position of the scale, the inverse of getScalePosition
+func (s Scale) getScaleValue(pos int, offset int) int {
+	n := len(s.Intervals)
+	return 1 + s.Tonic + floorDiv(pos, n)*12 + s.Intervals[floorMod(pos, n)] + offset
+}
+
+// the scale a motif is written in
+// motifs without a key are heard relative to their first note
+func (m Motif) getScale() Scale {
+	key := m.Key
+	if strings.TrimSpace(key) == "" {
+		for _, n := range m.Notes {
+			if n.Value >= 0 {
+				key = config.Notes[getValuePitchClass(n.Value)]
+				break
+			}
+		}
+	}
+	scale, err := newScale(key, m.Mode)
+	if err != nil {
+		scale, _ = newScale(key, defaultModeName)
+	}
+	return scale
+}
+
+// fill in the note fields that are relative to the motif
+// steps are counted in semitones from the first sounding note and intervals from the tonic,
+// rests take no steps and have no interval
+func (m *Motif) computeRelativeFields() {
+	scale := m.getScale()
+	first := -1
+	for i := range m.Notes {
+		n := &m.Notes[i]
+		if n.Value < 0 {
+			n.Steps = 0
+			n.Interval = -1
+			continue
+		}
+		if first < 0 {
+			first = n.Value
+		}
+		n.Steps = n.Value - first
+		n.Interval = scale.getInterval(n.Value)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// cost of a matching interval played with a different rhythm when rhythm is compared
+const rhythmMismatchCost float64 = 0.5
+
+// furthest apart (in octaves) two duration ratios can be and still count as the same rhythm
+const rhythmTolerance float64 = 0.15
+
+// SearchOptions : how closely motifs have to match a query
+type SearchOptions struct {
+	MaxDistance float64 // edits to the query's intervals a match can have, 0 for exact occurrences
+	Rhythm      bool    // compare the duration ratios of notes as well as their intervals
+}
+
+// SearchMatch : where a motif like the query is heard
+type SearchMatch struct {
+	File          string   `json:"file"`
+	Track         int      `json:"track"` // position of the motif among the tracks with notes, from 1
+	Name          string   `json:"name"`
+	Beat          int      `json:"beat"` // starting beat of the first note of the match
+	EndBeat       int      `json:"endBeat"`
+	Seconds       float64  `json:"seconds"` // from the start of the file to the match
+	Distance      float64  `json:"distance"`
+	Transposition int      `json:"transposition"` // semitones from the query's first note to the match's
+	Pitches       []string `json:"pitches"`
+}
+
+// motifStep : the move from one sounding note to the next, independent of key and tempo
+type motifStep struct {
+	interval int
+	ratio    float64 // log2 of the next note's duration over this one's
+}
+
+// the sounding notes of a motif in order and the steps between them
+func getMotifSteps(m Motif) ([]MotifNote, []motifStep) {
+	var sounding []MotifNote
+	for _, n := range m.Notes {
+		if n.Value >= 0 {
+			sounding = append(sounding, n)
+		}
+	}
+	var steps []motifStep
+	for idx := 1; idx < len(sounding); idx++ {
+		prev, n := sounding[idx-1], sounding[idx]
+		steps = append(steps, motifStep{
+			interval: n.Value - prev.Value,
+			ratio:    math.Log2(float64(n.Duration) / float64(prev.Duration)),
+		})
+	}
+	return sounding, steps
+}
+
+// cost of hearing one step in place of another
+func (opts SearchOptions) getStepCost(a motifStep, b motifStep) float64 {
+	if a.interval != b.interval {
+		return 1
+	}
+	if opts.Rhythm && math.Abs(a.ratio-b.ratio) > rhythmTolerance {
+		return rhythmMismatchCost
+	}
+	return 0
+}
+
+// motifSpan : steps of a motif matching the query and how far they are from it
+type motifSpan struct {
+	start, end int // steps [start, end) of the motif
+	distance   float64
+}
+
+// find the spans of a motif's steps within the search distance of the query's
+// the edit distance is counted with the match free to start at any step (Sellers' algorithm)
+// and spans are kept best first where they don't overlap a better one
+func findMotifSpans(query []motifStep, steps []motifStep, opts SearchOptions) []motifSpan {
+	m := len(query)
+	prev, prevStart := make([]float64, m+1), make([]int, m+1)
+	for i := range prev {
+		prev[i] = float64(i)
+	}
+	var spans []motifSpan
+	for j := 1; j <= len(steps); j++ {
+		cur, curStart := make([]float64, m+1), make([]int, m+1)
+		curStart[0] = j
+		for i := 1; i <= m; i++ {
+			cur[i], curStart[i] = prev[i-1]+opts.getStepCost(query[i-1], steps[j-1]), prevStart[i-1]
+			if d := cur[i-1] + 1; d < cur[i] {
+				cur[i], curStart[i] = d, curStart[i-1]
+			}
+			if d := prev[i] + 1; d < cur[i] {
+				cur[i], curStart[i] = d, prevStart[i]
+			}
+		}
+		if cur[m] <= opts.MaxDistance && curStart[m] < j {
+			spans = append(spans, motifSpan{start: curStart[m], end: j, distance: cur[m]})
+		}
+		prev, prevStart = cur, curStart
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].distance < spans[j].distance
+	})
+	var kept []motifSpan
+	for _, s := range spans {
+		overlaps := false
+		for _, k := range kept {
+			if s.start < k.end && k.start < s.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// take a query motif and the motifs of a file and return where the query is heard in them
+func searchMotifs(query Motif, file string, motifs []Motif, opts SearchOptions) ([]SearchMatch, error) {
+	query = joinTiedNotes(query)
+	querySounding, querySteps := getMotifSteps(query)
+	if len(querySteps) == 0 {
+		return nil, errors.New("query motif needs at least two notes")
+	}
+	if opts.MaxDistance >= float64(len(querySteps)) {
+		return nil, fmt.Errorf("max distance must be less than the %d intervals of the query", len(querySteps))
+	}
+	var matches []SearchMatch
+	for idx, m := range motifs {
+		// percussion notes are drum keys so they have no melody to match
+		if m.Percussion {
+			continue
+		}
+		m = joinTiedNotes(m)
+		sounding, steps := getMotifSteps(m)
+		for _, s := range findMotifSpans(querySteps, steps, opts) {
+			first, last := sounding[s.start], sounding[s.end]
+			match := SearchMatch{
+				File:          file,
+				Track:         idx + 1,
+				Name:          m.Name,
+				Beat:          first.StartingBeat,
+				EndBeat:       last.StartingBeat + last.Duration,
+				Seconds:       getDurationInSeconds(first.StartingBeat-1, m.Tempo, m.TimeSignature),
+				Distance:      s.distance,
+				Transposition: first.Value - querySounding[0].Value,
+			}
+			for _, n := range sounding[s.start : s.end+1] {
+				match.Pitches = append(match.Pitches, n.Pitch)
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+// take a query motif and MIDI files and return every match, closest first
+// files that fail to parse are skipped so one bad file doesn't stop the search
+func searchMIDIFiles(query Motif, files map[string]string, opts SearchOptions, parse ParseOptions) ([]SearchMatch, error) {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var matches []SearchMatch
+	for _, name := range names {
+		motifs, err := parseMotifsFromMIDIFile(files[name], parse)
+		if err != nil {
+			fmt.Println("Skipping", name, err)
+			continue
+		}
+		fileMatches, err := searchMotifs(query, name, motifs, opts)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, fileMatches...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+	return matches, nil
+}
+
+// the MIDI files of a corpus, a single file or every .mid and .midi file under a directory
+// returned by the name they are reported with
+func getCorpusFiles(corpus string) (map[string]string, error) {
+	files := map[string]string{}
+	info, err := os.Stat(corpus)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		files[corpus] = corpus
+		return files, nil
+	}
+	err = filepath.Walk(corpus, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if !info.IsDir() && (ext == ".mid" || ext == ".midi") {
+			files[path] = path
+		}
+		return nil
+	})
+	return files, err
+}
+
+// the motif of a file to search for, the first one with a melody
+func getQueryMotif(motifs []Motif) (Motif, error) {
+	for _, m := range motifs {
+		if !m.Percussion {
+			return m, nil
+		}
+	}
+	return Motif{}, errors.New("query has no melodic track")
+}
+
+// write the matches of a search as a table
+func writeSearchTable(w io.Writer, matches []SearchMatch) error {
+	if len(matches) == 0 {
+		_, err := fmt.Fprintln(w, "no matches")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\ttrack\tbeats\tseconds\tdistance\ttransposition\tnotes")
+	for _, m := range matches {
+		track := fmt.Sprint(m.Track)
+		if m.Name != "" {
+			track += " " + m.Name
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%d-%d\t%.2f\t%v\t%+d\t%v\n", m.File, track, m.Beat, m.EndBeat, m.Seconds, m.Distance, m.Transposition, strings.Join(m.Pitches, " "))
+	}
+	return tw.Flush()
+}
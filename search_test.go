@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// steps of a melody given as intervals, all notes the same length unless ratios are given
+func testSteps(intervals []int, ratios ...float64) []motifStep {
+	var steps []motifStep
+	for i, interval := range intervals {
+		s := motifStep{interval: interval}
+		if i < len(ratios) {
+			s.ratio = ratios[i]
+		}
+		steps = append(steps, s)
+	}
+	return steps
+}
+
+func TestFindMotifSpans(t *testing.T) {
+	// up a tone, up a tone, down a major third (c d e c)
+	query := testSteps([]int{2, 2, -4})
+	tests := []struct {
+		name  string
+		steps []motifStep
+		opts  SearchOptions
+		want  []motifSpan
+	}{
+		{"exact occurrences", testSteps([]int{5, 2, 2, -4, 7, 2, 2, -4}), SearchOptions{}, []motifSpan{{1, 4, 0}, {5, 8, 0}}},
+		{"no exact occurrence", testSteps([]int{2, 3, -4}), SearchOptions{}, nil},
+		{"too short", testSteps([]int{2, 2}), SearchOptions{MaxDistance: 0.5}, nil},
+		{"substitution", testSteps([]int{2, 3, -4}), SearchOptions{MaxDistance: 1}, []motifSpan{{0, 3, 1}}},
+		// an inserted step costs the same as a wrong one so the shorter span is kept
+		{"insertion or substitution", testSteps([]int{7, 2, 5, 2, -4}), SearchOptions{MaxDistance: 1}, []motifSpan{{2, 5, 1}}},
+		{"deletion", testSteps([]int{2, -4, 7}), SearchOptions{MaxDistance: 1}, []motifSpan{{0, 2, 1}}},
+		{"two edits over the distance", testSteps([]int{2, 3, -5}), SearchOptions{MaxDistance: 1}, nil},
+		// a near match overlapping an exact one gives way to it
+		{"overlapping spans", testSteps([]int{2, 2, 2, -4}), SearchOptions{MaxDistance: 1}, []motifSpan{{1, 4, 0}}},
+		{"closest first", testSteps([]int{2, 3, -4, 0, 2, 2, -4}), SearchOptions{MaxDistance: 1}, []motifSpan{{4, 7, 0}, {0, 3, 1}}},
+		// the rhythm only counts when asked for and then costs less than a wrong interval
+		{"rhythm ignored", testSteps([]int{2, 2, -4}, 1, -1, 0), SearchOptions{}, []motifSpan{{0, 3, 0}}},
+		{"rhythm mismatch", testSteps([]int{2, 2, -4}, 1, -1, 0), SearchOptions{MaxDistance: 1, Rhythm: true}, []motifSpan{{0, 3, 1}}},
+		{"rhythm within tolerance", testSteps([]int{2, 2, -4}, 0.1, -0.1, 0), SearchOptions{Rhythm: true}, []motifSpan{{0, 3, 0}}},
+	}
+	for _, tt := range tests {
+		if got := findMotifSpans(query, tt.steps, tt.opts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMotifs(t *testing.T) {
+	melody := func(values []int, durations []int) Motif {
+		m := Motif{Tempo: Tempo{Type: "bpm", Units: 120}, TimeSignature: TimeSignature{Beat: 4, Unit: 4}}
+		beat := 1
+		for i, v := range values {
+			m.Notes = append(m.Notes, MotifNote{Note: newNote(v, durations[i]), StartingBeat: beat})
+			beat += durations[i]
+		}
+		return m
+	}
+	// c4 d4 e4 c4
+	query := melody([]int{49, 51, 53, 49}, []int{4, 4, 4, 4})
+	// a bar of rest then the query a fifth higher with a rest inside it and its last note held over the bar line
+	track := melody([]int{-1, 56, 58, -1, 60, 56}, []int{64, 4, 4, 4, 4, 32})
+	track = notateMotif(track)
+	drums := melody([]int{36, 38, 40, 36}, []int{4, 4, 4, 4})
+	drums.Percussion = true
+	matches, err := searchMotifs(query, "song.mid", []Motif{drums, track}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SearchMatch{{
+		File:          "song.mid",
+		Track:         2,
+		Beat:          65,
+		EndBeat:       113,
+		Seconds:       2,
+		Transposition: 7,
+		Pitches:       []string{"g4", "a4", "b4", "g4"},
+	}}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("got %+v, want %+v", matches, want)
+	}
+	if _, err := searchMotifs(melody([]int{49}, []int{4}), "song.mid", []Motif{track}, SearchOptions{}); err == nil {
+		t.Error("a one note query didn't fail")
+	}
+	if _, err := searchMotifs(query, "song.mid", []Motif{track}, SearchOptions{MaxDistance: 3}); err == nil {
+		t.Error("a distance of every interval didn't fail")
+	}
+}
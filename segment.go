@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// a gap between two notes is a phrase boundary once its cues add up to this
+const phraseBoundaryScore float64 = 1
+
+// cues of a phrase boundary, a rest of an eighth note or more is enough on its own
+const longNoteCue float64 = 0.6   // the note before is at least a beat and twice the typical length
+const barLineCue float64 = 0.4    // the note after starts a bar
+const repetitionCue float64 = 0.6 // the note after starts the phrase's opening intervals again
+
+// intervals compared to tell when a phrase starts again
+const phraseRepeatIntervals int = 3
+
+// phrases are at least this many notes and are cut at the next bar line once they are this many bars long
+const minPhraseNotes int = 3
+const maxPhraseBars int = 8
+
+// take motifs and return them cut into phrases, motifs that are one phrase are returned whole
+// percussion tracks are kept whole as their notes have no melody to phrase
+func segmentMotifs(motifs []Motif) []Motif {
+	var segmented []Motif
+	for idx, m := range motifs {
+		if m.Percussion {
+			segmented = append(segmented, m)
+			continue
+		}
+		segmented = append(segmented, segmentMotif(m, idx+1)...)
+	}
+	return segmented
+}
+
+// take the motif of a track and return its phrases, each starting on beat 1 with its offset into the track
+func segmentMotif(m Motif, track int) []Motif {
+	cuts := getPhraseCuts(m)
+	if len(cuts) < 2 {
+		return []Motif{m}
+	}
+	var phrases []Motif
+	for idx, cut := range cuts {
+		end := -1
+		if idx+1 < len(cuts) {
+			end = cuts[idx+1]
+		}
+		phrase := getMotifSlice(m, cut, end)
+		phrase.ID = fmt.Sprintf("track-%d-phrase-%d", track, idx+1)
+		phrase.Name = fmt.Sprintf("phrase %d", idx+1)
+		if m.Name != "" {
+			phrase.Name = m.Name + " " + phrase.Name
+		}
+		phrases = append(phrases, phrase)
+	}
+	return phrases
+}
+
+// the starting beats of the phrases of a motif
+func getPhraseCuts(m Motif) []int {
+	sounding, steps := getMotifSteps(m)
+	if len(sounding) == 0 {
+		return nil
+	}
+	unitsPerBeat := getUnitsPerBeat(m.TimeSignature)
+	unitsPerBar := getUnitsPerBar(m.TimeSignature)
+	var durations []int
+	for _, n := range sounding {
+		durations = append(durations, n.Duration)
+	}
+	sort.Ints(durations)
+	median := durations[len(durations)/2]
+
+	cuts := []int{sounding[0].StartingBeat}
+	phraseStart := 0
+	for idx := 0; idx+1 < len(sounding); idx++ {
+		n, next := sounding[idx], sounding[idx+1]
+		rest := next.StartingBeat - (n.StartingBeat + n.Duration)
+		score := 0.0
+		if rest > 0 {
+			score += float64(rest) / float64(unitsPerBeat/2)
+		}
+		if n.Duration >= unitsPerBeat && n.Duration >= 2*median {
+			score += longNoteCue
+		}
+		onBarLine := (next.StartingBeat-1)%unitsPerBar == 0
+		if onBarLine {
+			score += barLineCue
+		}
+		if isPhraseRepeat(steps, phraseStart, idx+1) {
+			score += repetitionCue
+		}
+		long := onBarLine && next.StartingBeat-sounding[phraseStart].StartingBeat >= maxPhraseBars*unitsPerBar
+		if idx+1-phraseStart >= minPhraseNotes && (score >= phraseBoundaryScore || long) {
+			cuts = append(cuts, next.StartingBeat)
+			phraseStart = idx + 1
+		}
+	}
+	return cuts
+}
+
+// whether the intervals from a note repeat the opening intervals of the phrase it is in
+func isPhraseRepeat(steps []motifStep, phraseStart int, idx int) bool {
+	if idx-phraseStart < phraseRepeatIntervals || idx+phraseRepeatIntervals > len(steps) {
+		return false
+	}
+	for k := 0; k < phraseRepeatIntervals; k++ {
+		if steps[phraseStart+k].interval != steps[idx+k].interval {
+			return false
+		}
+	}
+	return true
+}
+
+// take motif and return the notes starting from one beat up to another (-1 for the end) as a motif of its own
+// rests at either end are dropped and controllers hold the value they had where the slice starts
+func getMotifSlice(m Motif, from int, to int) Motif {
+	var notes []MotifNote
+	for _, n := range m.Notes {
+		if n.StartingBeat < from || (to >= 0 && n.StartingBeat >= to) {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	for len(notes) > 0 && notes[0].Value < 0 {
+		notes = notes[1:]
+	}
+	for len(notes) > 0 && notes[len(notes)-1].Value < 0 {
+		notes = notes[:len(notes)-1]
+	}
+	offset := from - 1
+	if len(notes) > 0 {
+		offset = notes[0].StartingBeat - 1
+	}
+	slice := m
+	slice.Notes = nil
+	for _, n := range notes {
+		n.StartingBeat -= offset
+		slice.Notes = append(slice.Notes, n)
+	}
+	slicePoints := func(points []ControlPoint) []ControlPoint {
+		var sliced []ControlPoint
+		if len(points) > 0 && points[0].Beat <= offset+1 {
+			sliced = append(sliced, ControlPoint{Beat: 1, Value: getControlValue(points, float64(offset+1))})
+		}
+		for _, p := range points {
+			if p.Beat > offset+1 && (to < 0 || p.Beat < to) {
+				sliced = append(sliced, ControlPoint{Beat: p.Beat - offset, Value: p.Value})
+			}
+		}
+		return sliced
+	}
+	slice.PitchBend = slicePoints(m.PitchBend)
+	slice.Modulation = slicePoints(m.Modulation)
+	slice.Offset = m.Offset + offset
+	slice.computeRelativeFields()
+	return slice
+}
+
+// take motif and return it delayed to where it was cut from its track
+func placeMotif(m Motif) Motif {
+	placed := delayMotif(m, m.Offset)
+	placed.Offset = 0
+	return placed
+}
@@ -10,6 +10,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,10 +31,18 @@ const downloadTTLMins int64 = 1
 
 // APIResponse : response for /download/<filename>
 type APIResponse struct {
-	URL              string    `json:"url"`
-	CreatedTimeStamp time.Time `json:"created"`
-	Message          string    `json:"message"`
-	Success          bool      `json:"success"`
+	URL              string          `json:"url"`
+	CreatedTimeStamp time.Time       `json:"created"`
+	Message          string          `json:"message"`
+	Success          bool            `json:"success"`
+	Loudness         *LoudnessReport `json:"loudness,omitempty"`
+	Problems         []MotifProblem  `json:"problems,omitempty"` // why the motifs failed validation
+}
+
+// TransformRequest : body of /transform, Motivic JSON motifs and the chain to run them through
+type TransformRequest struct {
+	Motifs     []Motif         `json:"motifs"`
+	Transforms []TransformSpec `json:"transforms"`
 }
 
 // FileSystem custom file system handler
@@ -121,6 +131,12 @@ func serve() {
 	http.Handle("/", http.StripPrefix(strings.TrimRight(path, "/"), http.FileServer(http.Dir(directory))))
 	http.HandleFunc("/upload/midi", midiFileUploadHandler)
 	http.HandleFunc("/download/", fileDownloadHandler)
+	http.HandleFunc("/stream/midi", midiFileStreamHandler)
+	http.HandleFunc("/inspect/midi", midiFileInspectHandler)
+	http.HandleFunc("/analyze/midi", midiFileAnalyzeHandler)
+	http.HandleFunc("/search/midi", midiFileSearchHandler)
+	http.HandleFunc("/chords/midi", midiFileChordsHandler)
+	http.HandleFunc("/transform", motifTransformHandler)
 	fmt.Println("...listening at " + domain + ":" + port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
@@ -156,7 +172,7 @@ func serveDownloadFile(w http.ResponseWriter, r *http.Request, filePath string,
 	http.ServeFile(w, r, filePath)
 }
 
-func conversionResponse(w http.ResponseWriter, outputFilePath string, fileName string) {
+func conversionResponse(w http.ResponseWriter, outputFilePath string, fileName string, loudness *LoudnessReport) {
 	data := APIResponse{}
 	tsCreated := time.Now()
 	// conversion failed
@@ -167,7 +183,7 @@ func conversionResponse(w http.ResponseWriter, outputFilePath string, fileName s
 		tsExpires := tsCreated.Local().Add(time.Minute * time.Duration(downloadTTLMins))
 		strExpires := tsExpires.Format(time.RFC1123)
 		fileURL := getAbsoluteURL("download/"+fileName, "")
-		data = APIResponse{URL: fileURL, CreatedTimeStamp: tsCreated, Success: true, Message: "File converted"}
+		data = APIResponse{URL: fileURL, CreatedTimeStamp: tsCreated, Success: true, Message: "File converted", Loudness: loudness}
 		fmt.Println(strExpires)
 		w.Header().Set("Expires", strExpires)
 		w.WriteHeader(http.StatusOK)
@@ -179,6 +195,168 @@ func conversionResponse(w http.ResponseWriter, outputFilePath string, fileName s
 	go expireFile(outputFilePath)
 }
 
+// read the tuning fields of an upload
+// Scala files can't be named by path over HTTP so they are uploaded alongside the MIDI file
+func getTuningFormValues(r *http.Request, key string) (string, float64) {
+	tuning := r.Form.Get("tuning")
+	if strings.HasSuffix(strings.ToLower(tuning), ".scl") {
+		fmt.Println("Ignoring Scala file path in upload, use the myScalaFile field")
+		tuning = defaultTuningName
+	}
+	if sclFile, sclFileHandle, err := r.FormFile("myScalaFile"); err == nil {
+		defer sclFile.Close()
+		tuning = inputFileDir + key + "_" + filepath.Base(sclFileHandle.Filename)
+		if !strings.HasSuffix(strings.ToLower(tuning), ".scl") {
+			tuning += ".scl"
+		}
+		saveFile(sclFile, sclFileHandle, tuning)
+		go expireFile(tuning)
+	}
+	referencePitch, err := strconv.ParseFloat(r.Form.Get("referencePitch"), 64)
+	if err != nil {
+		referencePitch = defaultReferencePitch
+	}
+	return tuning, referencePitch
+}
+
+// read a number field of an upload, a field left out takes the default
+func getFloatFormValue(r *http.Request, field string, fallback float64) (float64, error) {
+	value := r.Form.Get(field)
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback, fmt.Errorf("%v %q isn't a number", field, value)
+	}
+	return f, nil
+}
+
+// read a whole number field of an upload, a field left out takes the default
+func getIntFormValue(r *http.Request, field string, fallback int) (int, error) {
+	value := r.Form.Get(field)
+	if value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%v %q isn't a whole number", field, value)
+	}
+	return i, nil
+}
+
+// read the mastering fields of an upload, limiting and dither are on unless turned off
+func getMasteringFormValues(r *http.Request) (MasteringOptions, error) {
+	target, err := getFloatFormValue(r, "target", 0)
+	if err != nil {
+		return MasteringOptions{}, err
+	}
+	limit := r.Form.Get("limit") != "false"
+	dither := r.Form.Get("dither") != "false"
+	return getMasteringOptions(r.Form.Get("normalize"), target, limit, defaultLimiterCeilingDBFS, dither)
+}
+
+// 1. parse the uploaded MIDI file and 2. save it to disk
+// returns the saved path and the random key naming this conversion's files
+func saveMIDIUpload(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	fmt.Println("Parsing uploaded file...")
+	tsCreated := time.Now()
+	// setting max memory allocation of file to 10MB the rest will be stored automatically in tmp files
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
+	r.ParseMultipartForm(maxUploadSizeBytes)
+	midiFile, midiFileHandle, err := r.FormFile("myMIDIFile")
+	if err != nil {
+		return "", "", err
+	}
+	defer midiFile.Close()
+	fmt.Printf("Uploaded File: \t%+v at %v\n", midiFileHandle.Filename, tsCreated)
+	fmt.Printf("File Size: \t%+vkb\n", midiFileHandle.Size)
+	fmt.Printf("MIME Header: \t%+v\n", midiFileHandle.Header)
+	fmt.Println("Successfully uploaded file")
+
+	randomString := getRandomString(8)
+	inputFilePath := inputFileDir + randomString + "_" + midiFileHandle.Filename
+	saveFile(midiFile, midiFileHandle, inputFilePath)
+	go expireFile(inputFilePath)
+	return inputFilePath, randomString, nil
+}
+
+// read the fields of an upload that say how its motifs are parsed, fields that can't be used are an error
+func getParseFormValues(r *http.Request) (ParseOptions, error) {
+	var err error
+	opts := ParseOptions{}
+	q := &opts.Quantize
+	q.Triplets = r.Form.Get("triplets") == "true"
+	if q.Grid, err = getIntFormValue(r, "quantize", 0); err != nil {
+		return opts, err
+	}
+	if q.Strength, err = getFloatFormValue(r, "strength", 1); err != nil {
+		return opts, err
+	}
+	if q.Swing, err = getFloatFormValue(r, "swing", straightSwing); err != nil {
+		return opts, err
+	}
+	if q.MinRest, err = getIntFormValue(r, "minRest", 0); err != nil {
+		return opts, err
+	}
+	if err = q.validate(); err != nil {
+		return opts, err
+	}
+	opts.Segment = r.Form.Get("segment") == "true"
+	if opts.Variations.Count, err = getIntFormValue(r, "variations", 0); err != nil {
+		return opts, err
+	}
+	seed, err := getIntFormValue(r, "seed", 1)
+	if err != nil {
+		return opts, err
+	}
+	opts.Variations.Seed = int64(seed)
+	if opts.Variations.Ornament, err = getFloatFormValue(r, "ornament", defaultOrnamentChance); err != nil {
+		return opts, err
+	}
+	if style := r.Form.Get("harmonize"); style != "" {
+		if _, ok := harmonyStyles[style]; !ok {
+			return opts, fmt.Errorf("unknown harmony style %v", style)
+		}
+		opts.Harmony = style
+	}
+	if opts.Transforms, err = parseTransformChain(r.Form.Get("transform")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// read the render options of an upload, fields that can't be used are an error
+func getRenderFormValues(r *http.Request, key string) (RenderOptions, error) {
+	var err error
+	opts := RenderOptions{Format: wavFile, WaveForm: r.Form.Get("myWaveForm")}
+	if strings.ToLower(r.Form.Get("format")) == aiffFile {
+		opts.Format = aiffFile
+	}
+	opts.Pan, _ = strconv.ParseFloat(r.Form.Get("pan"), 64)
+	opts.Vibrato.Rate, _ = strconv.ParseFloat(r.Form.Get("vibratoRate"), 64)
+	opts.Vibrato.Depth, _ = strconv.ParseFloat(r.Form.Get("vibratoDepth"), 64)
+	opts.Vibrato.Delay, _ = strconv.ParseFloat(r.Form.Get("vibratoDelay"), 64)
+	opts.Glide, _ = strconv.ParseFloat(r.Form.Get("glide"), 64)
+	opts.Tuning, opts.ReferencePitch = getTuningFormValues(r, key)
+	// presets are sent inline as JSON, the server never reads effect files named by clients
+	if opts.Effects, err = parseEffectChain(r.Form.Get("effects")); err != nil {
+		return opts, err
+	}
+	if opts.Mastering, err = getMasteringFormValues(r); err != nil {
+		return opts, err
+	}
+	opts.Metronome.Click = r.Form.Get("click") == "true"
+	opts.Metronome.CountIn, _ = strconv.Atoi(r.Form.Get("countIn"))
+	opts.Metronome.Stem = r.Form.Get("clickStem") == "true"
+	if opts.Parse, err = getParseFormValues(r); err != nil {
+		return opts, err
+	}
+	opts.MIDI = r.Form.Get("midi") == "true"
+	opts.MusicXML = r.Form.Get("musicxml") == "true"
+	return opts, nil
+}
+
 // REST API to accept files for conversion
 // TODO: handle polyphonic MIDI - support or return helpful exception response
 // TODO: increase conversion types:
@@ -194,54 +372,381 @@ func midiFileUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	fmt.Println("MIDI File Upload Endpoint Hit")
-	// 1. PARSE UPLOADED FILE
-	fmt.Println("Parsing uploaded file...")
-	tsCreated := time.Now()
-	// setting max memory allocation of file to 10MB the rest will be stored automatically in tmp files
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
-	r.ParseMultipartForm(maxUploadSizeBytes)
-	midiFile, midiFileHandle, err := r.FormFile("myMIDIFile")
+	inputFilePath, randomString, err := saveMIDIUpload(w, r)
 	if err != nil {
 		fmt.Println("Error parsing the file upload")
 		fmt.Println(err)
 		return
 	}
-	defer midiFile.Close()
-	fmt.Printf("Uploaded File: \t%+v at %v\n", midiFileHandle.Filename, tsCreated)
-	fmt.Printf("File Size: \t%+vkb\n", midiFileHandle.Size)
-	fmt.Printf("MIME Header: \t%+v\n", midiFileHandle.Header)
-	fmt.Println("Successfully uploaded file")
-
-	// 2. SAVE UPLOADED MIDI FILE TO DISK
-	randomString := getRandomString(8)
-	inputFilePath := inputFileDir + randomString + "_" + midiFileHandle.Filename
-	saveFile(midiFile, midiFileHandle, inputFilePath)
-	go expireFile(inputFilePath)
 
 	// 3. CONVERT MIDI FILE TO AUDIO FILE
 	fmt.Println("Converting copied file...")
-	waveFormName := r.Form.Get("myWaveForm")
 	outputFileName := r.Form.Get("wavFileName")
-	wavFileoutputFilePath, _ := getFilePathFromName(outputFileDir, randomString, outputFileName, "wav")
+	opts, err := getRenderFormValues(r, randomString)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	wavFileoutputFilePath, _ := getFilePathFromName(outputFileDir, randomString, outputFileName, opts.Format)
 	// channel to wait for go routine response
-	c := make(chan bool)
-	go convertMIDIFileToWAVFile(inputFilePath, wavFileoutputFilePath, waveFormName, c)
-	success := <-c
+	c := make(chan ConversionResult)
+	go convertMIDIFileToWAVFile(inputFilePath, wavFileoutputFilePath, opts, c)
+	result := <-c
 	go expireFile(wavFileoutputFilePath)
+	filesToZip := []string{wavFileoutputFilePath}
+	if result.Manifest != nil {
+		// stems and their manifest go in the zip with the mixdown
+		manifestFilePath := getManifestPath(wavFileoutputFilePath)
+		if err := saveStemManifest(result.Manifest, manifestFilePath, randomString); err != nil {
+			fmt.Println("Error saving stem manifest:", err)
+		} else {
+			filesToZip = append(filesToZip, manifestFilePath)
+		}
+		filesToZip = append(filesToZip, result.Manifest.getFiles()...)
+		for _, stemFile := range filesToZip[1:] {
+			go expireFile(stemFile)
+		}
+	}
+	if result.MIDIFile != "" {
+		filesToZip = append(filesToZip, result.MIDIFile)
+		go expireFile(result.MIDIFile)
+	}
+	if result.ScoreFile != "" {
+		filesToZip = append(filesToZip, result.ScoreFile)
+		go expireFile(result.ScoreFile)
+	}
+
+	if result.Problems != nil {
+		problemsResponse(w, "Invalid motifs", result.Problems)
+		return
+	}
 
 	// 4. RETURN URL OF NEW FILE
 	var zipFileOutputPath string = ""
 	var zipFileName string = ""
-	if success {
+	if result.Success {
 		zipFileOutputPath, zipFileName = getFilePathFromName(outputFileDir, randomString, outputFileName, "zip")
-		filesToZip := []string{wavFileoutputFilePath}
 		if err := zipFiles(zipFileOutputPath, filesToZip, randomString); err != nil {
 			panic(err)
 		}
 		fmt.Println("Zipped File:", zipFileOutputPath)
 
 	}
-	conversionResponse(w, zipFileOutputPath, zipFileName)
+	conversionResponse(w, zipFileOutputPath, zipFileName, result.Loudness)
+}
+
+// REST API to convert an uploaded file and stream the audio back while it renders
+// nothing is written to the output dir and the response size is known up front
+// so clients can start playing long files before the render has finished
+func midiFileStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at stream endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("MIDI File Stream Endpoint Hit")
+	inputFilePath, randomString, err := saveMIDIUpload(w, r)
+	if err != nil {
+		fmt.Println("Error parsing the file upload")
+		fmt.Println(err)
+		return
+	}
+	opts, err := getRenderFormValues(r, randomString)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	format := opts.Format
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, opts.Parse)
+	if err != nil {
+		fmt.Println("ERROR: parseMotifsFromMIDIFile", err)
+		motifErrorResponse(w, "Conversion failed", err)
+		return
+	}
+	// a single stream can't carry stems so the click is always mixed in
+	opts.Metronome.Stem = false
+	tracks := getRenderTracks(motifs, opts)
+	// the stream is built before anything is sent so its length can size the response
+	s, err := newMixStream(tracks, opts.Effects)
+	if err != nil {
+		fmt.Println("ERROR: newMixStream", err)
+		conversionResponse(w, "", "", nil)
+		return
+	}
+	size := getStreamHeaderSize(format) + s.Length*s.Format.NumChannels*audioBitDepth/8
+	_, fileName := getFilePathFromName("", randomString, r.Form.Get("wavFileName"), format)
+	w.Header().Set("Content-Type", "audio/"+format)
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", getFileNameFromPath(fileName, randomString)))
+	loudness, err := streamTracks(s, opts.Mastering, format, w)
+	if err != nil {
+		// the status has already been sent so all we can do is stop
+		fmt.Println("ERROR: streamTracks", err)
+		return
+	}
+	if verbose {
+		fmt.Printf("LOUDNESS:\t%+v\n", *loudness)
+	}
+}
+
+// REST API to parse an uploaded file and return its motifs as JSON
+func midiFileInspectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at inspect endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("MIDI File Inspect Endpoint Hit")
+	inputFilePath, _, err := saveMIDIUpload(w, r)
+	if err != nil {
+		fmt.Println("Error parsing the file upload")
+		fmt.Println(err)
+		return
+	}
+	parse, err := getParseFormValues(r)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, parse)
+	if err != nil {
+		fmt.Println("ERROR: parseMotifsFromMIDIFile", err)
+		motifErrorResponse(w, "Conversion failed", err)
+		return
+	}
+	motifsResponse(w, motifs)
+}
+
+// REST API to parse an uploaded file and return the melodic statistics of its motifs as JSON
+func midiFileAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at analyze endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("MIDI File Analyze Endpoint Hit")
+	inputFilePath, _, err := saveMIDIUpload(w, r)
+	if err != nil {
+		fmt.Println("Error parsing the file upload")
+		fmt.Println(err)
+		return
+	}
+	parse, err := getParseFormValues(r)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, parse)
+	if err != nil {
+		fmt.Println("ERROR: parseMotifsFromMIDIFile", err)
+		motifErrorResponse(w, "Conversion failed", err)
+		return
+	}
+	jsonData, _ := json.MarshalIndent(analyzeMotifs(motifs), "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// REST API to summarize the chords of an uploaded MIDI file
+// the chord track is returned as JSON unless a musicxml or midi format is asked for, which is returned as a download URL
+func midiFileChordsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at chords endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("MIDI File Chords Endpoint Hit")
+	inputFilePath, randomString, err := saveMIDIUpload(w, r)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	format := r.Form.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	ext, ok := chordFormats[format]
+	if !ok {
+		errorResponse(w, http.StatusBadRequest, "Unknown chord format "+format)
+		return
+	}
+	parse, err := getParseFormValues(r)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	motifs, err := parseMotifsFromMIDIFile(inputFilePath, parse)
+	if err != nil {
+		motifErrorResponse(w, "Invalid MIDI file", err)
+		return
+	}
+	ct := detectChordTrack(motifs)
+	if format == "json" {
+		if ct.Chords == nil {
+			ct.Chords = []Chord{}
+		}
+		jsonData, _ := json.MarshalIndent(ct, "", "    ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+		return
+	}
+	outputFileName := r.Form.Get("fileName")
+	if outputFileName == "" {
+		outputFileName = "chords"
+	}
+	outputFilePath, fileName := getFilePathFromName(outputFileDir, randomString, outputFileName, ext)
+	// ignore error if dir already exists
+	_ = os.Mkdir(outputFileDir, 0777)
+	if err := saveChordTrack(ct, format, outputFilePath); err != nil {
+		fmt.Println("Error saving chords:", err)
+		outputFilePath = ""
+	}
+	conversionResponse(w, outputFilePath, fileName, nil)
+}
+
+// REST API to search uploaded MIDI files (corpus fields) for a query motif
+// the query is a MIDI file (myMIDIFile) whose first melodic track is searched for or a Motivic JSON motif (query)
+func midiFileSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at search endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("MIDI File Search Endpoint Hit")
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
+	if err := r.ParseMultipartForm(maxUploadSizeBytes); err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	key := getRandomString(8)
+	parse, err := getParseFormValues(r)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid options: "+err.Error())
+		return
+	}
+	query, err := getSearchQuery(r, key, parse)
+	if err != nil {
+		motifErrorResponse(w, "Invalid query", err)
+		return
+	}
+	files := map[string]string{}
+	for _, handle := range r.MultipartForm.File["corpus"] {
+		file, err := handle.Open()
+		if err != nil {
+			fmt.Println("Error opening corpus file", handle.Filename, err)
+			continue
+		}
+		filePath := inputFileDir + key + "_" + filepath.Base(handle.Filename)
+		saveFile(file, handle, filePath)
+		file.Close()
+		files[handle.Filename] = filePath
+		go expireFile(filePath)
+	}
+	if len(files) == 0 {
+		errorResponse(w, http.StatusBadRequest, "Upload MIDI files to search in the corpus field")
+		return
+	}
+	opts := SearchOptions{Rhythm: r.Form.Get("rhythm") == "true"}
+	if opts.MaxDistance, err = strconv.ParseFloat(r.Form.Get("maxDistance"), 64); err != nil {
+		opts.MaxDistance = 1
+	}
+	// the transforms shape the query, the corpus is searched as it was written
+	matches, err := searchMIDIFiles(query, files, opts, ParseOptions{Quantize: parse.Quantize})
+	if err != nil {
+		errorResponse(w, http.StatusUnprocessableEntity, "Search failed: "+err.Error())
+		return
+	}
+	if matches == nil {
+		matches = []SearchMatch{}
+	}
+	jsonData, _ := json.MarshalIndent(matches, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// read the query motif of a search from its JSON field or uploaded MIDI file
+func getSearchQuery(r *http.Request, key string, parse ParseOptions) (Motif, error) {
+	if q := r.Form.Get("query"); q != "" {
+		var query Motif
+		if err := json.Unmarshal([]byte(q), &query); err != nil {
+			return query, err
+		}
+		if err := checkMotifNotes(query); err != nil {
+			return query, err
+		}
+		return applyTransformChain(query, parse.Transforms)
+	}
+	file, handle, err := r.FormFile("myMIDIFile")
+	if err != nil {
+		return Motif{}, err
+	}
+	defer file.Close()
+	filePath := inputFileDir + key + "_" + filepath.Base(handle.Filename)
+	saveFile(file, handle, filePath)
+	go expireFile(filePath)
+	motifs, err := parseMotifsFromMIDIFile(filePath, parse)
+	if err != nil {
+		return Motif{}, err
+	}
+	return getQueryMotif(motifs)
+}
+
+// REST API to transform motifs sent as Motivic JSON
+// e.g. {"motifs": [...], "transforms": [{"type": "transpose", "params": {"semitones": 2}}, {"type": "retrograde"}]}
+func motifTransformHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Println(r.Method, "not accepted at transform endpoint")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	fmt.Println("Motif Transform Endpoint Hit")
+	var req TransformRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid Motivic JSON: "+err.Error())
+		return
+	}
+	if err := validateMotifs(req.Motifs); err != nil {
+		problemsResponse(w, "Invalid motifs: "+err.Error(), getMotifProblems(err))
+		return
+	}
+	motifs, err := applyTransformChains(req.Motifs, req.Transforms)
+	if err != nil {
+		motifErrorResponse(w, "Transform failed", err)
+		return
+	}
+	motifsResponse(w, notateMotifs(motifs))
+}
+
+func motifsResponse(w http.ResponseWriter, motifs []Motif) {
+	jsonData, _ := json.MarshalIndent(motifs, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+func errorResponse(w http.ResponseWriter, status int, message string) {
+	data := APIResponse{CreatedTimeStamp: time.Now(), Success: false, Message: message}
+	jsonData, _ := json.MarshalIndent(data, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// respond to motifs that couldn't be worked on with the problems found validating them
+func motifErrorResponse(w http.ResponseWriter, message string, err error) {
+	if problems := getMotifProblems(err); problems != nil {
+		problemsResponse(w, message+": "+err.Error(), problems)
+		return
+	}
+	errorResponse(w, http.StatusUnprocessableEntity, message+": "+err.Error())
+}
+
+func problemsResponse(w http.ResponseWriter, message string, problems []MotifProblem) {
+	data := APIResponse{CreatedTimeStamp: time.Now(), Success: false, Message: message, Problems: problems}
+	jsonData, _ := json.MarshalIndent(data, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(jsonData)
 }
 
 func fileDownloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/go-audio/audio"
+)
+
+// name of the voice that renders with the loaded SoundFont
+const soundFontVoice string = "soundfont"
+
+// velocity used for notes until Motif carries MIDI velocities
+const defaultVelocity int = 100
+
+// the 100 dB of attenuation in centibels that SF2 envelope decay times are measured over
+const sfFullAttenuationCB float64 = 1000
+
+// SF2 generator operators used by the renderer
+// see section 8.1.3 of the SoundFont 2.04 spec
+const (
+	sfGenStartAddrsOffset           uint16 = 0
+	sfGenEndAddrsOffset             uint16 = 1
+	sfGenStartloopAddrsOffset       uint16 = 2
+	sfGenEndloopAddrsOffset         uint16 = 3
+	sfGenStartAddrsCoarseOffset     uint16 = 4
+	sfGenEndAddrsCoarseOffset       uint16 = 12
+	sfGenDelayVolEnv                uint16 = 33
+	sfGenAttackVolEnv               uint16 = 34
+	sfGenHoldVolEnv                 uint16 = 35
+	sfGenDecayVolEnv                uint16 = 36
+	sfGenSustainVolEnv              uint16 = 37
+	sfGenReleaseVolEnv              uint16 = 38
+	sfGenInstrument                 uint16 = 41
+	sfGenKeyRange                   uint16 = 43
+	sfGenVelRange                   uint16 = 44
+	sfGenStartloopAddrsCoarseOffset uint16 = 45
+	sfGenInitialAttenuation         uint16 = 48
+	sfGenEndloopAddrsCoarseOffset   uint16 = 50
+	sfGenCoarseTune                 uint16 = 51
+	sfGenFineTune                   uint16 = 52
+	sfGenSampleID                   uint16 = 53
+	sfGenSampleModes                uint16 = 54
+	sfGenScaleTuning                uint16 = 56
+	sfGenOverridingRootKey          uint16 = 58
+)
+
+// generator values that apply when a zone doesn't set them
+var sfGenDefaults = map[uint16]int16{
+	sfGenDelayVolEnv:       -12000,
+	sfGenAttackVolEnv:      -12000,
+	sfGenHoldVolEnv:        -12000,
+	sfGenDecayVolEnv:       -12000,
+	sfGenReleaseVolEnv:     -12000,
+	sfGenScaleTuning:       100,
+	sfGenOverridingRootKey: -1,
+}
+
+// preset level generators that are not added on top of the instrument level ones
+var sfGenNonAdditive = map[uint16]bool{
+	sfGenStartAddrsOffset:           true,
+	sfGenEndAddrsOffset:             true,
+	sfGenStartloopAddrsOffset:       true,
+	sfGenEndloopAddrsOffset:         true,
+	sfGenStartAddrsCoarseOffset:     true,
+	sfGenEndAddrsCoarseOffset:       true,
+	sfGenInstrument:                 true,
+	sfGenKeyRange:                   true,
+	sfGenVelRange:                   true,
+	sfGenStartloopAddrsCoarseOffset: true,
+	sfGenEndloopAddrsCoarseOffset:   true,
+	sfGenSampleID:                   true,
+	sfGenSampleModes:                true,
+	sfGenOverridingRootKey:          true,
+}
+
+// SoundFont : sample bank parsed from an SF2 file
+type SoundFont struct {
+	Name        string
+	Samples     []int16
+	Presets     []SoundFontPreset
+	Instruments []SoundFontInstrument
+	SampleInfo  []SoundFontSample
+}
+
+// SoundFontZone : generator list of a preset or instrument zone
+type SoundFontZone struct {
+	Generators map[uint16]int16
+}
+
+// SoundFontPreset : SF2 preset addressed by bank and MIDI program
+type SoundFontPreset struct {
+	Name    string
+	Program int
+	Bank    int
+	Global  *SoundFontZone
+	Zones   []SoundFontZone
+}
+
+// SoundFontInstrument : SF2 instrument made of sample zones
+type SoundFontInstrument struct {
+	Name   string
+	Global *SoundFontZone
+	Zones  []SoundFontZone
+}
+
+// SoundFontSample : SF2 sample header
+type SoundFontSample struct {
+	Name            string
+	Start           uint32
+	End             uint32
+	StartLoop       uint32
+	EndLoop         uint32
+	SampleRate      uint32
+	OriginalPitch   uint8
+	PitchCorrection int8
+}
+
+// SoundFont loaded at startup with the -soundfont flag
+var soundFont *SoundFont
+
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+// split a RIFF chunk body into its sub chunks
+func readRIFFChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if 8+size > len(data) {
+			return chunks, fmt.Errorf("chunk %v overruns its parent", id)
+		}
+		chunks = append(chunks, riffChunk{id: id, data: data[8 : 8+size]})
+		// chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+func readFixedString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+// take an SF2 file on disk and return the parsed SoundFont
+func parseSoundFontFile(filePath string) (*SoundFont, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "sfbk" {
+		return nil, errors.New("not an SF2 file")
+	}
+	chunks, err := readRIFFChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	sf := &SoundFont{}
+	pdta := map[string][]byte{}
+	for _, c := range chunks {
+		if c.id != "LIST" || len(c.data) < 4 {
+			continue
+		}
+		subChunks, err := readRIFFChunks(c.data[4:])
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range subChunks {
+			switch string(c.data[0:4]) {
+			case "INFO":
+				if sc.id == "INAM" {
+					sf.Name = readFixedString(sc.data)
+				}
+			case "sdta":
+				if sc.id == "smpl" {
+					sf.Samples = make([]int16, len(sc.data)/2)
+					for i := range sf.Samples {
+						sf.Samples[i] = int16(binary.LittleEndian.Uint16(sc.data[i*2:]))
+					}
+				}
+			case "pdta":
+				pdta[sc.id] = sc.data
+			}
+		}
+	}
+	for _, id := range []string{"phdr", "pbag", "pgen", "inst", "ibag", "igen", "shdr"} {
+		if _, ok := pdta[id]; !ok {
+			return nil, fmt.Errorf("SF2 file is missing the %v chunk", id)
+		}
+	}
+	if len(sf.Samples) == 0 {
+		return nil, errors.New("SF2 file has no sample data")
+	}
+	presetZones := parseSoundFontZones(pdta["pbag"], pdta["pgen"])
+	instZones := parseSoundFontZones(pdta["ibag"], pdta["igen"])
+
+	// instruments (the last record is the terminal EOI record)
+	inst := pdta["inst"]
+	for i := 0; i+44 <= len(inst); i += 22 {
+		bagStart := int(binary.LittleEndian.Uint16(inst[i+20:]))
+		bagEnd := int(binary.LittleEndian.Uint16(inst[i+42:]))
+		global, zones := splitGlobalZone(instZones, bagStart, bagEnd, sfGenSampleID)
+		sf.Instruments = append(sf.Instruments, SoundFontInstrument{
+			Name:   readFixedString(inst[i : i+20]),
+			Global: global,
+			Zones:  zones,
+		})
+	}
+
+	// presets (the last record is the terminal EOP record)
+	phdr := pdta["phdr"]
+	for i := 0; i+76 <= len(phdr); i += 38 {
+		bagStart := int(binary.LittleEndian.Uint16(phdr[i+24:]))
+		bagEnd := int(binary.LittleEndian.Uint16(phdr[i+62:]))
+		global, zones := splitGlobalZone(presetZones, bagStart, bagEnd, sfGenInstrument)
+		sf.Presets = append(sf.Presets, SoundFontPreset{
+			Name:    readFixedString(phdr[i : i+20]),
+			Program: int(binary.LittleEndian.Uint16(phdr[i+20:])),
+			Bank:    int(binary.LittleEndian.Uint16(phdr[i+22:])),
+			Global:  global,
+			Zones:   zones,
+		})
+	}
+
+	// sample headers (the last record is the terminal EOS record)
+	shdr := pdta["shdr"]
+	for i := 0; i+92 <= len(shdr); i += 46 {
+		sf.SampleInfo = append(sf.SampleInfo, SoundFontSample{
+			Name:            readFixedString(shdr[i : i+20]),
+			Start:           binary.LittleEndian.Uint32(shdr[i+20:]),
+			End:             binary.LittleEndian.Uint32(shdr[i+24:]),
+			StartLoop:       binary.LittleEndian.Uint32(shdr[i+28:]),
+			EndLoop:         binary.LittleEndian.Uint32(shdr[i+32:]),
+			SampleRate:      binary.LittleEndian.Uint32(shdr[i+36:]),
+			OriginalPitch:   shdr[i+40],
+			PitchCorrection: int8(shdr[i+41]),
+		})
+	}
+	return sf, nil
+}
+
+// turn bag and generator records into one zone per bag
+func parseSoundFontZones(bags []byte, gens []byte) []SoundFontZone {
+	var zones []SoundFontZone
+	for i := 0; i+8 <= len(bags); i += 4 {
+		genStart := int(binary.LittleEndian.Uint16(bags[i:]))
+		genEnd := int(binary.LittleEndian.Uint16(bags[i+4:]))
+		z := SoundFontZone{Generators: map[uint16]int16{}}
+		for g := genStart; g < genEnd && g*4+4 <= len(gens); g++ {
+			oper := binary.LittleEndian.Uint16(gens[g*4:])
+			z.Generators[oper] = int16(binary.LittleEndian.Uint16(gens[g*4+2:]))
+		}
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+// the first zone of a preset or instrument is global when it doesn't end in its terminal generator
+func splitGlobalZone(all []SoundFontZone, start int, end int, terminal uint16) (*SoundFontZone, []SoundFontZone) {
+	if start < 0 || end > len(all) || start >= end {
+		return nil, nil
+	}
+	zones := all[start:end]
+	if _, ok := zones[0].Generators[terminal]; !ok {
+		global := zones[0]
+		return &global, zones[1:]
+	}
+	return nil, zones
+}
+
+// key and velocity ranges are stored as lo/hi byte pairs
+// a zone without its own range takes the range of the global zone
+func (z SoundFontZone) matches(global *SoundFontZone, key int, vel int) bool {
+	for _, gen := range []uint16{sfGenKeyRange, sfGenVelRange} {
+		amount, ok := z.Generators[gen]
+		if !ok && global != nil {
+			amount, ok = global.Generators[gen]
+		}
+		if !ok {
+			continue
+		}
+		lo, hi := int(uint16(amount)&0xff), int(uint16(amount)>>8)
+		v := key
+		if gen == sfGenVelRange {
+			v = vel
+		}
+		if v < lo || v > hi {
+			return false
+		}
+	}
+	return true
+}
+
+// a bank the SoundFont doesn't have falls back to the program in the General MIDI bank
+func (sf *SoundFont) getPreset(bank int, program int) *SoundFontPreset {
+	for _, b := range []int{bank, 0} {
+		for i, p := range sf.Presets {
+			if p.Bank == b && p.Program == program {
+				return &sf.Presets[i]
+			}
+		}
+	}
+	// fall back to the first melodic preset rather than rendering silence
+	for i, p := range sf.Presets {
+		if p.Bank == 0 {
+			return &sf.Presets[i]
+		}
+	}
+	if len(sf.Presets) > 0 {
+		return &sf.Presets[0]
+	}
+	return nil
+}
+
+// flatten generators for every sample zone that sounds for this key and velocity
+func (sf *SoundFont) getVoiceGenerators(preset *SoundFontPreset, key int, vel int) []map[uint16]int16 {
+	var voices []map[uint16]int16
+	for _, pz := range preset.Zones {
+		if !pz.matches(preset.Global, key, vel) {
+			continue
+		}
+		instIdx := int(pz.Generators[sfGenInstrument])
+		if instIdx < 0 || instIdx >= len(sf.Instruments) {
+			continue
+		}
+		inst := sf.Instruments[instIdx]
+		for _, iz := range inst.Zones {
+			if !iz.matches(inst.Global, key, vel) {
+				continue
+			}
+			gens := map[uint16]int16{}
+			for g, v := range sfGenDefaults {
+				gens[g] = v
+			}
+			// instrument level: local zones override the global zone
+			if inst.Global != nil {
+				for g, v := range inst.Global.Generators {
+					gens[g] = v
+				}
+			}
+			for g, v := range iz.Generators {
+				gens[g] = v
+			}
+			// preset level: values are offsets added to the instrument level
+			presetGens := map[uint16]int16{}
+			if preset.Global != nil {
+				for g, v := range preset.Global.Generators {
+					presetGens[g] = v
+				}
+			}
+			for g, v := range pz.Generators {
+				presetGens[g] = v
+			}
+			for g, v := range presetGens {
+				if !sfGenNonAdditive[g] {
+					gens[g] += v
+				}
+			}
+			voices = append(voices, gens)
+		}
+	}
+	return voices
+}
+
+// convert SF2 timecents to seconds
+func timecentsToSeconds(tc int16) float64 {
+	return math.Pow(2, float64(tc)/1200)
+}
+
+// convert SF2 centibels of attenuation to a linear gain
+func centibelsToGain(cb float64) float64 {
+	return math.Pow(10, -cb/200)
+}
+
+// volume envelope of an SF2 voice (delay, attack, hold, decay, sustain, release)
+type soundFontEnvelope struct {
+	delay, attack, hold, decay, release float64
+	sustain                             float64 // centibels of attenuation
+}
+
+func newSoundFontEnvelope(gens map[uint16]int16) soundFontEnvelope {
+	return soundFontEnvelope{
+		delay:   timecentsToSeconds(gens[sfGenDelayVolEnv]),
+		attack:  timecentsToSeconds(gens[sfGenAttackVolEnv]),
+		hold:    timecentsToSeconds(gens[sfGenHoldVolEnv]),
+		decay:   timecentsToSeconds(gens[sfGenDecayVolEnv]),
+		sustain: float64(gens[sfGenSustainVolEnv]),
+		release: timecentsToSeconds(gens[sfGenReleaseVolEnv]),
+	}
+}
+
+// level of the envelope before the note is released
+func (e soundFontEnvelope) level(t float64) float64 {
+	if t < e.delay {
+		return 0
+	}
+	t -= e.delay
+	if t < e.attack {
+		return t / e.attack
+	}
+	t -= e.attack
+	if t < e.hold {
+		return 1
+	}
+	t -= e.hold
+	// the decay falls linearly in dB, the decay time being how long a fall of the full 100 dB would take
+	return centibelsToGain(math.Min(sfFullAttenuationCB*t/e.decay, e.sustain))
+}
+
+// take a MIDI bank, program, key and duration and return audio buffer of one note played by the SoundFont
+func (sf *SoundFont) generateAudio(bank int, program int, key int, vel int, durSecs float64) *audio.FloatBuffer {
+	factor := float64(audio.IntMaxSignedValue(audioBitDepth))
+	data := make([]float64, int(math.Ceil(float64(audioSampleRate)*durSecs)))
+	buf := &audio.FloatBuffer{Data: data, Format: voiceFormat}
+	preset := sf.getPreset(bank, program)
+	// rests and missing presets are silence
+	if key < 0 || preset == nil {
+		return buf
+	}
+	for _, gens := range sf.getVoiceGenerators(preset, key, vel) {
+		sampleIdx := int(gens[sfGenSampleID])
+		if sampleIdx < 0 || sampleIdx >= len(sf.SampleInfo) {
+			continue
+		}
+		s := sf.SampleInfo[sampleIdx]
+		start := int(s.Start) + int(gens[sfGenStartAddrsOffset]) + int(gens[sfGenStartAddrsCoarseOffset])*32768
+		end := int(s.End) + int(gens[sfGenEndAddrsOffset]) + int(gens[sfGenEndAddrsCoarseOffset])*32768
+		loopStart := int(s.StartLoop) + int(gens[sfGenStartloopAddrsOffset]) + int(gens[sfGenStartloopAddrsCoarseOffset])*32768
+		loopEnd := int(s.EndLoop) + int(gens[sfGenEndloopAddrsOffset]) + int(gens[sfGenEndloopAddrsCoarseOffset])*32768
+		if start < 0 || end > len(sf.Samples) || start >= end || s.SampleRate == 0 {
+			continue
+		}
+		// sample modes 1 and 3 loop, 3 stops looping on release
+		loopMode := gens[sfGenSampleModes] & 3
+		looping := (loopMode == 1 || loopMode == 3) && loopStart >= start && loopEnd <= end && loopEnd-loopStart > 1
+
+		rootKey := int(s.OriginalPitch)
+		if gens[sfGenOverridingRootKey] >= 0 {
+			rootKey = int(gens[sfGenOverridingRootKey])
+		}
+		semitones := float64(key-rootKey)*float64(gens[sfGenScaleTuning])/100 +
+			float64(gens[sfGenCoarseTune]) +
+			float64(gens[sfGenFineTune])/100 +
+			float64(s.PitchCorrection)/100
+		step := math.Pow(2, semitones/12) * float64(s.SampleRate) / float64(audioSampleRate)
+
+		env := newSoundFontEnvelope(gens)
+		// the release has to fit inside the note so the next note starts from silence
+		release := math.Min(env.release, durSecs/2)
+		gateSecs := durSecs - release
+		velGain := float64(vel) / 127
+		gain := centibelsToGain(float64(gens[sfGenInitialAttenuation])) * velGain * velGain * factor / 32768
+
+		pos := float64(start)
+		for i := range data {
+			t := float64(i) / float64(audioSampleRate)
+			amp := env.level(math.Min(t, gateSecs))
+			if t > gateSecs {
+				amp *= math.Max(0, 1-(t-gateSecs)/release)
+				if loopMode == 3 {
+					looping = false
+				}
+			}
+			if looping && pos >= float64(loopEnd) {
+				pos -= float64(loopEnd - loopStart)
+			}
+			idx := int(pos)
+			if idx+1 >= end {
+				break
+			}
+			// linear interpolation between neighbouring samples
+			frac := pos - float64(idx)
+			sample := float64(sf.Samples[idx])*(1-frac) + float64(sf.Samples[idx+1])*frac
+			data[i] += sample * amp * gain
+			pos += step
+		}
+	}
+	return buf
+}
+
+// read the -soundfont file into memory for the renderer
+func loadSoundFont(filePath string) error {
+	sf, err := parseSoundFontFile(filePath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded SoundFont %v with %d presets\n", sf.Name, len(sf.Presets))
+	soundFont = sf
+	return nil
+}
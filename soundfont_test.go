@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// generator of a test zone
+type testGen struct {
+	oper   uint16
+	amount int16
+}
+
+// parse an SF2 file with two instruments on one sample and three presets:
+// piano (bank 0 program 0) playing keys 0-59 from a global zone, organ (bank 0 program 16)
+// and bright piano (bank 1 program 0)
+func loadTestSoundFont(t *testing.T) *SoundFont {
+	t.Helper()
+	chunk := func(id string, data []byte) []byte {
+		var b bytes.Buffer
+		b.WriteString(id)
+		binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+		b.Write(data)
+		if len(data)%2 == 1 {
+			b.WriteByte(0)
+		}
+		return b.Bytes()
+	}
+	list := func(kind string, chunks ...[]byte) []byte {
+		return chunk("LIST", append([]byte(kind), bytes.Join(chunks, nil)...))
+	}
+	name := func(s string) []byte {
+		b := make([]byte, 20)
+		copy(b, s)
+		return b
+	}
+	// bag and generator records for zones, with the terminal records at the end
+	zones := func(zs ...[]testGen) ([]byte, []byte) {
+		var bags, gens bytes.Buffer
+		genIdx := 0
+		for _, z := range zs {
+			binary.Write(&bags, binary.LittleEndian, []uint16{uint16(genIdx), 0})
+			for _, g := range z {
+				binary.Write(&gens, binary.LittleEndian, g.oper)
+				binary.Write(&gens, binary.LittleEndian, g.amount)
+				genIdx++
+			}
+		}
+		binary.Write(&bags, binary.LittleEndian, []uint16{uint16(genIdx), 0})
+		binary.Write(&gens, binary.LittleEndian, []uint16{0, 0})
+		return bags.Bytes(), gens.Bytes()
+	}
+
+	pbag, pgen := zones(
+		[]testGen{{sfGenKeyRange, 59 << 8}}, // piano global zone, keys 0-59
+		[]testGen{{sfGenInstrument, 0}},     // piano
+		[]testGen{{sfGenInstrument, 1}},     // organ
+		[]testGen{{sfGenInstrument, 0}},     // bright piano
+	)
+	var phdr bytes.Buffer
+	for _, p := range []struct {
+		name          string
+		program, bank uint16
+		bag           uint16
+	}{{"Piano", 0, 0, 0}, {"Organ", 16, 0, 2}, {"Bright Piano", 0, 1, 3}, {"EOP", 0, 0, 4}} {
+		phdr.Write(name(p.name))
+		binary.Write(&phdr, binary.LittleEndian, []uint16{p.program, p.bank, p.bag})
+		binary.Write(&phdr, binary.LittleEndian, []uint32{0, 0, 0})
+	}
+
+	ibag, igen := zones(
+		[]testGen{{sfGenSampleID, 0}},
+		[]testGen{{sfGenKeyRange, 127<<8 | 36}, {sfGenSampleID, 0}},
+	)
+	var inst bytes.Buffer
+	for i, n := range []string{"Piano", "Organ", "EOI"} {
+		inst.Write(name(n))
+		binary.Write(&inst, binary.LittleEndian, uint16(i))
+	}
+
+	var shdr bytes.Buffer
+	for _, s := range []struct {
+		name                           string
+		start, end, loopStart, loopEnd uint32
+		rate                           uint32
+		pitch                          uint8
+		correction                     int8
+	}{{"Sine", 8, 72, 16, 64, 22050, 69, -5}, {"EOS", 0, 0, 0, 0, 0, 0, 0}} {
+		shdr.Write(name(s.name))
+		binary.Write(&shdr, binary.LittleEndian, []uint32{s.start, s.end, s.loopStart, s.loopEnd, s.rate})
+		binary.Write(&shdr, binary.LittleEndian, s.pitch)
+		binary.Write(&shdr, binary.LittleEndian, s.correction)
+		binary.Write(&shdr, binary.LittleEndian, []uint16{0, 1})
+	}
+
+	body := bytes.Join([][]byte{
+		[]byte("sfbk"),
+		list("INFO", chunk("INAM", []byte("Test Bank\x00"))),
+		list("sdta", chunk("smpl", make([]byte, 160))),
+		list("pdta",
+			chunk("phdr", phdr.Bytes()), chunk("pbag", pbag), chunk("pgen", pgen),
+			chunk("inst", inst.Bytes()), chunk("ibag", ibag), chunk("igen", igen),
+			chunk("shdr", shdr.Bytes())),
+	}, nil)
+	dir, err := ioutil.TempDir("", "soundfont")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "test.sf2")
+	if err := ioutil.WriteFile(filePath, chunk("RIFF", body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sf, err := parseSoundFontFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func TestParseSoundFontFile(t *testing.T) {
+	sf := loadTestSoundFont(t)
+	if sf.Name != "Test Bank" || len(sf.Samples) != 80 {
+		t.Errorf("got name %q and %d samples, want Test Bank and 80", sf.Name, len(sf.Samples))
+	}
+	presets := []struct {
+		name          string
+		program, bank int
+		global        bool
+		zones         int
+	}{
+		{"Piano", 0, 0, true, 1},
+		{"Organ", 16, 0, false, 1},
+		{"Bright Piano", 0, 1, false, 1},
+	}
+	if len(sf.Presets) != len(presets) {
+		t.Fatalf("got %d presets, want %d", len(sf.Presets), len(presets))
+	}
+	for i, want := range presets {
+		p := sf.Presets[i]
+		if p.Name != want.name || p.Program != want.program || p.Bank != want.bank || (p.Global != nil) != want.global || len(p.Zones) != want.zones {
+			t.Errorf("preset %d: got %v program %d bank %d global %v with %d zones, want %+v", i, p.Name, p.Program, p.Bank, p.Global != nil, len(p.Zones), want)
+		}
+	}
+	if len(sf.Instruments) != 2 || sf.Instruments[1].Name != "Organ" || sf.Instruments[1].Zones[0].Generators[sfGenKeyRange] != 127<<8|36 {
+		t.Errorf("got instruments %+v", sf.Instruments)
+	}
+	want := SoundFontSample{Name: "Sine", Start: 8, End: 72, StartLoop: 16, EndLoop: 64, SampleRate: 22050, OriginalPitch: 69, PitchCorrection: -5}
+	if len(sf.SampleInfo) != 1 || sf.SampleInfo[0] != want {
+		t.Errorf("got samples %+v, want %+v", sf.SampleInfo, want)
+	}
+}
+
+func TestGetPreset(t *testing.T) {
+	sf := loadTestSoundFont(t)
+	tests := []struct {
+		bank, program int
+		want          string
+	}{
+		{0, 0, "Piano"},
+		{0, 16, "Organ"},
+		{1, 0, "Bright Piano"},
+		// a bank the SoundFont doesn't have plays the program from bank 0
+		{5, 16, "Organ"},
+		// a program it doesn't have plays the first melodic preset
+		{0, 40, "Piano"},
+		{1, 40, "Piano"},
+	}
+	for _, tt := range tests {
+		if got := sf.getPreset(tt.bank, tt.program); got == nil || got.Name != tt.want {
+			t.Errorf("getPreset(%d, %d) = %v, want %v", tt.bank, tt.program, got, tt.want)
+		}
+	}
+}
+
+func TestGetVoiceGenerators(t *testing.T) {
+	sf := loadTestSoundFont(t)
+	tests := []struct {
+		program, key int
+		voices       int
+	}{
+		// the piano's global zone limits it to keys below 60
+		{0, 40, 1},
+		{0, 59, 1},
+		{0, 60, 0},
+		// the organ's instrument zone starts at key 36
+		{16, 35, 0},
+		{16, 36, 1},
+		{16, 100, 1},
+	}
+	for _, tt := range tests {
+		if got := sf.getVoiceGenerators(sf.getPreset(0, tt.program), tt.key, defaultVelocity); len(got) != tt.voices {
+			t.Errorf("program %d key %d: got %d voices, want %d", tt.program, tt.key, len(got), tt.voices)
+		}
+	}
+}
+
+func TestSoundFontEnvelopeDecay(t *testing.T) {
+	// a decay of 1s down to a sustain 60 dB below the peak
+	env := soundFontEnvelope{delay: 0, attack: 0, hold: 0, decay: 1, sustain: 600}
+	tests := []struct {
+		t    float64
+		want float64
+	}{
+		{0, 1},
+		// the decay falls 100 dB over the decay time so it is 10 dB down after 0.1s
+		{0.1, 0.31623},
+		{0.3, 0.031623},
+		// and holds at the sustain level once it gets there
+		{0.6, 0.001},
+		{2, 0.001},
+	}
+	for _, tt := range tests {
+		if got := env.level(tt.t); got < tt.want*0.999 || got > tt.want*1.001 {
+			t.Errorf("level(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// suffix of the manifest describing a render with stems
+const manifestSuffix string = "_manifest"
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// StemManifest : describes the mixdown and stems of a render so they can be imported together
+type StemManifest struct {
+	Mixdown       string          `json:"mixdown"`
+	SampleRate    int             `json:"sampleRate"`
+	BitDepth      int             `json:"bitDepth"`
+	Channels      int             `json:"channels"`
+	Tempo         Tempo           `json:"tempo"`
+	TimeSignature TimeSignature   `json:"timeSignature"`
+	Loudness      *LoudnessReport `json:"loudness"`
+	Stems         []StemInfo      `json:"stems"`
+}
+
+// StemInfo : one stem of a render, stems start together and sum to the mixdown before limiting
+type StemInfo struct {
+	File       string  `json:"file"`
+	Track      int     `json:"track"` // position of the motif among the tracks with notes, 0 for the click
+	Name       string  `json:"name"`
+	Program    int     `json:"program"`
+	Percussion bool    `json:"percussion"`
+	Pan        float64 `json:"pan"`
+}
+
+// paths of the stem files
+func (sm *StemManifest) getFiles() []string {
+	var files []string
+	for _, stem := range sm.Stems {
+		files = append(files, stem.File)
+	}
+	return files
+}
+
+func newStemManifest(mixdown string, m Motif, loudness *LoudnessReport) *StemManifest {
+	return &StemManifest{
+		Mixdown:       mixdown,
+		SampleRate:    audioFormat.SampleRate,
+		BitDepth:      audioBitDepth,
+		Channels:      audioFormat.NumChannels,
+		Tempo:         m.Tempo,
+		TimeSignature: m.TimeSignature,
+		Loudness:      loudness,
+	}
+}
+
+// path of a track's stem next to the mixdown, named after the track when it has a name
+func getTrackStemPath(outputFilePath string, idx int, name string) string {
+	ext := filepath.Ext(outputFilePath)
+	stemPath := fmt.Sprintf("%v_%d", strings.TrimSuffix(outputFilePath, ext), idx+1)
+	if slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-"); slug != "" {
+		stemPath += "_" + slug
+	}
+	return stemPath + ext
+}
+
+// path of the manifest written next to the mixdown
+func getManifestPath(outputFilePath string) string {
+	return strings.TrimSuffix(outputFilePath, filepath.Ext(outputFilePath)) + manifestSuffix + ".json"
+}
+
+// render a single track to its own file through the effects the track is rendered with
+// stems are limited and dithered like the mixdown but take its normalization gain
+// instead of being normalized on their own so they keep their place in the mix
+func renderStem(track RenderTrack, mastering MasteringOptions, gainDB float64, format string, filePath string) error {
+	track.Gain *= dbToGain(gainDB)
+	s, err := newMixStream([]RenderTrack{track}, track.Options.Effects)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	mastering = MasteringOptions{Limit: mastering.Limit, Ceiling: mastering.Ceiling, Dither: mastering.Dither}
+	_, err = streamTracks(s, mastering, format, f)
+	return err
+}
+
+// save the manifest with files named as they are downloaded,
+// without the key that prefixes them in the output dir
+func saveStemManifest(manifest *StemManifest, filePath string, key string) error {
+	getName := func(path string) string {
+		name := filepath.Base(path)
+		if key != "" {
+			name = strings.TrimPrefix(name, key+"_")
+		}
+		return name
+	}
+	saved := *manifest
+	saved.Mixdown = getName(manifest.Mixdown)
+	saved.Stems = nil
+	for _, stem := range manifest.Stems {
+		stem.File = getName(stem.File)
+		saved.Stems = append(saved.Stems, stem)
+	}
+	jsonData, err := json.MarshalIndent(saved, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, jsonData, 0666)
+}
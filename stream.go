@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/go-audio/audio"
+)
+
+// frames rendered per pull, small enough to keep memory flat and large enough to keep writes efficient
+const streamBlockFrames int = 4096
+
+// StreamVoice : rendered note still sounding in the blocks being pulled
+type streamVoice struct {
+	start int
+	data  []float64
+}
+
+// MotifStream : pull based renderer that produces a motif's mix a block at a time
+// notes are only rendered when the stream reaches them so memory is bounded by the
+// longest note rather than the length of the motif
+type MotifStream struct {
+	Format *audio.Format
+	Length int // frames in the stream
+	motif  Motif
+	render noteRenderer
+	next   int // next note to render
+	voices []streamVoice
+	pos    int       // frames pulled so far
+	gains  []float64 // level of the motif in each channel of the output
+	mono   []float64
+}
+
+func newMotifStream(m Motif, opts RenderOptions) (*MotifStream, error) {
+	render, err := newNoteRenderer(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MotifStream{
+		Format: audioFormat,
+		Length: getMotifLength(m),
+		motif:  m,
+		render: render,
+		gains:  getChannelGains(getMotifPan(m, opts.Pan), audioFormat.NumChannels),
+	}, nil
+}
+
+// level of a mono voice in each channel of an output, placed with a constant power pan law when it is stereo
+func getChannelGains(pan float64, numChannels int) []float64 {
+	if numChannels == 1 {
+		return []float64{1}
+	}
+	left, right := getPanGains(pan)
+	return []float64{left, right}
+}
+
+// Read fills buf with the next interleaved frames and returns how many frames it filled, 0 once the stream has ended
+func (s *MotifStream) Read(buf []float64) int {
+	frames := len(buf) / s.Format.NumChannels
+	if remaining := s.Length - s.pos; frames > remaining {
+		frames = remaining
+	}
+	if frames <= 0 {
+		return 0
+	}
+	end := s.pos + frames
+	// render notes that start in this block
+	for ; s.next < len(s.motif.Notes); s.next++ {
+		start, _ := getNoteSpan(s.motif.Notes[s.next], s.motif)
+		if start >= end {
+			break
+		}
+		if data := s.render(s.next); len(data) > 0 {
+			s.voices = append(s.voices, streamVoice{start: start, data: data})
+		}
+	}
+	// mix the sounding notes and let go of those that have finished
+	if cap(s.mono) < frames {
+		s.mono = make([]float64, frames)
+	}
+	mono := s.mono[:frames]
+	for i := range mono {
+		mono[i] = 0
+	}
+	sounding := s.voices[:0]
+	for _, v := range s.voices {
+		for i := range mono {
+			if j := s.pos + i - v.start; j >= 0 && j < len(v.data) {
+				mono[i] += v.data[j]
+			}
+		}
+		if v.start+len(v.data) > end {
+			sounding = append(sounding, v)
+		}
+	}
+	s.voices = sounding
+	numChannels := s.Format.NumChannels
+	for i, v := range mono {
+		for ch, gain := range s.gains {
+			buf[i*numChannels+ch] = v * gain
+		}
+	}
+	s.pos = end
+	return frames
+}
+
+// RenderTrack : motif mixed into a render along with the options it is rendered with
+type RenderTrack struct {
+	Motif   Motif
+	Options RenderOptions
+	Gain    float64 // linear level of the track in the mix
+}
+
+// MixStream : sums the streams of several tracks into one and runs the sum through an effect chain
+type MixStream struct {
+	Format  *audio.Format
+	Length  int // frames in the longest track including effect tails
+	tracks  []RenderTrack
+	chain   []EffectSpec
+	streams []*MotifStream
+	gains   []float64
+	effects []Effect
+	pos     int
+	block   []float64
+}
+
+func newMixStream(tracks []RenderTrack, chain []EffectSpec) (*MixStream, error) {
+	effects, tailFrames, err := newEffectChain(chain, audioFormat.NumChannels)
+	if err != nil {
+		return nil, err
+	}
+	mix := &MixStream{Format: audioFormat, tracks: tracks, chain: chain, effects: effects}
+	for _, t := range tracks {
+		s, err := newMotifStream(t.Motif, t.Options)
+		if err != nil {
+			return nil, err
+		}
+		if s.Length > mix.Length {
+			mix.Length = s.Length
+		}
+		mix.streams = append(mix.streams, s)
+		mix.gains = append(mix.gains, t.Gain)
+	}
+	mix.Length += tailFrames
+	return mix, nil
+}
+
+// a new stream of the same mix from its start
+func (s *MixStream) restart() (*MixStream, error) {
+	return newMixStream(s.tracks, s.chain)
+}
+
+// Read fills buf with the next interleaved frames of the mix and returns how many frames it filled, 0 once every track has ended
+func (s *MixStream) Read(buf []float64) int {
+	numChannels := s.Format.NumChannels
+	frames := len(buf) / numChannels
+	if remaining := s.Length - s.pos; frames > remaining {
+		frames = remaining
+	}
+	if frames <= 0 {
+		return 0
+	}
+	out := buf[:frames*numChannels]
+	for i := range out {
+		out[i] = 0
+	}
+	if cap(s.block) < len(out) {
+		s.block = make([]float64, len(out))
+	}
+	for idx, track := range s.streams {
+		n := track.Read(s.block[:len(out)])
+		for i, v := range s.block[:n*numChannels] {
+			out[i] += v * s.gains[idx]
+		}
+	}
+	for _, e := range s.effects {
+		e.Process(out)
+	}
+	s.pos += frames
+	return frames
+}
+
+// StreamEncoder : writes PCM audio to a writer that can't seek
+// the header is written up front so the number of frames has to be known before the first block
+type streamEncoder struct {
+	w      io.Writer
+	order  binary.ByteOrder
+	dither bool
+	rng    *rand.Rand
+	bytes  []byte
+}
+
+// size in bytes of the header written ahead of the samples
+func getStreamHeaderSize(format string) int {
+	if format == aiffFile {
+		return 54
+	}
+	return 44
+}
+
+func newStreamEncoder(format string, w io.Writer, numFrames int, f *audio.Format, dither bool) (*streamEncoder, error) {
+	bytesPerSample := audioBitDepth / 8
+	dataSize := uint32(numFrames * f.NumChannels * bytesPerSample)
+	var header []interface{}
+	var order binary.ByteOrder
+	switch format {
+	case wavFile:
+		order = binary.LittleEndian
+		header = []interface{}{
+			[]byte("RIFF"), 36 + dataSize, []byte("WAVEfmt "),
+			uint32(16), uint16(1), uint16(f.NumChannels), uint32(f.SampleRate),
+			uint32(f.SampleRate * f.NumChannels * bytesPerSample), uint16(f.NumChannels * bytesPerSample), uint16(audioBitDepth),
+			[]byte("data"), dataSize,
+		}
+	case aiffFile:
+		order = binary.BigEndian
+		header = []interface{}{
+			[]byte("FORM"), 46 + dataSize, []byte("AIFFCOMM"),
+			uint32(18), uint16(f.NumChannels), uint32(numFrames), uint16(audioBitDepth), audio.IntToIEEEFloat(f.SampleRate),
+			[]byte("SSND"), 8 + dataSize, uint32(0), uint32(0),
+		}
+	default:
+		return nil, fmt.Errorf("can't stream %v files", format)
+	}
+	for _, v := range header {
+		if err := binary.Write(w, order, v); err != nil {
+			return nil, err
+		}
+	}
+	return &streamEncoder{w: w, order: order, dither: dither, rng: rand.New(rand.NewSource(ditherSeed))}, nil
+}
+
+// quantize interleaved samples and write them
+func (e *streamEncoder) write(data []float64, f *audio.Format) error {
+	ints := quantizeBuffer(&audio.FloatBuffer{Data: data, Format: f}, e.dither, e.rng)
+	bytesPerSample := audioBitDepth / 8
+	if n := len(ints.Data) * bytesPerSample; cap(e.bytes) < n {
+		e.bytes = make([]byte, n)
+	}
+	b := e.bytes[:len(ints.Data)*bytesPerSample]
+	for i, v := range ints.Data {
+		for j := 0; j < bytesPerSample; j++ {
+			shift := uint(8 * j)
+			if e.order == binary.BigEndian {
+				shift = uint(8 * (bytesPerSample - 1 - j))
+			}
+			b[i*bytesPerSample+j] = byte(v >> shift)
+		}
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+// encode a mix to w as it is rendered
+// normalization needs the level of the whole render so it is measured by a first pass
+// that is thrown away, everything else happens in a single pass
+func streamTracks(s *MixStream, mastering MasteringOptions, format string, w io.Writer) (*LoudnessReport, error) {
+	var err error
+	block := make([]float64, streamBlockFrames*s.Format.NumChannels)
+	in := newLoudnessMeter(s.Format)
+	gainDB := 0.0
+	if mastering.Normalize != "" {
+		for n := s.Read(block); n > 0; n = s.Read(block) {
+			in.add(block[:n*s.Format.NumChannels])
+		}
+		gainDB = getNormalizationGain(mastering, in.getPeakDBFS(), in.getLoudness())
+		if s, err = s.restart(); err != nil {
+			return nil, err
+		}
+	}
+	enc, err := newStreamEncoder(format, w, s.Length, s.Format, mastering.Dither)
+	if err != nil {
+		return nil, err
+	}
+	var lim *limiter
+	if mastering.Limit {
+		lim = newLimiter(s.Format, mastering.Ceiling)
+	}
+	out := newLoudnessMeter(s.Format)
+	gain := dbToGain(gainDB)
+	emit := func(data []float64) error {
+		out.add(data)
+		return enc.write(data, s.Format)
+	}
+	for n := s.Read(block); n > 0; n = s.Read(block) {
+		data := block[:n*s.Format.NumChannels]
+		if mastering.Normalize == "" {
+			in.add(data)
+		}
+		if gainDB != 0 {
+			for i := range data {
+				data[i] *= gain
+			}
+		}
+		if lim != nil {
+			data = lim.process(data)
+		}
+		if err := emit(data); err != nil {
+			return nil, err
+		}
+	}
+	if lim != nil {
+		if err := emit(lim.flush()); err != nil {
+			return nil, err
+		}
+	}
+	return &LoudnessReport{
+		InputLUFS:      clampDB(in.getLoudness()),
+		InputPeakDBFS:  clampDB(in.getPeakDBFS()),
+		GainDB:         gainDB,
+		OutputLUFS:     clampDB(out.getLoudness()),
+		OutputPeakDBFS: clampDB(out.getPeakDBFS()),
+	}, nil
+}
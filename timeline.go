@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-audio/audio"
+)
+
+// NoteRenderer : renders the note at an index of a motif to be placed at its start on the timeline
+// notes have to be rendered in order and rests render as nil
+type noteRenderer func(idx int) []float64
+
+// absolute sample offset of a beat position (StartingBeat is 1-based)
+// positions are computed from the start of the motif rather than accumulated note by note
+// so rounding never drifts
+func getSampleOffset(beat int, m Motif) int {
+	unitSecs := getDurationInSeconds(1, m.Tempo, m.TimeSignature)
+	return int(math.Round(float64(beat-1) * unitSecs * float64(audioSampleRate)))
+}
+
+// first sample and sample after the last of a note on the timeline
+func getNoteSpan(n MotifNote, m Motif) (int, int) {
+	return getSampleOffset(n.StartingBeat, m), getSampleOffset(n.StartingBeat+n.Duration, m)
+}
+
+// number of samples the motif needs to play through its last note
+func getMotifLength(m Motif) int {
+	length := 0
+	for _, n := range m.Notes {
+		if _, end := getNoteSpan(n, m); end > length {
+			length = end
+		}
+	}
+	return length
+}
+
+// seconds spanned by a number of samples, used to ask voices for exactly that many samples
+func getSamplesInSeconds(samples int) float64 {
+	// step back from the boundary so math.Ceil in the voices can't round up an extra sample
+	return (float64(samples) - 1e-6) / float64(audioSampleRate)
+}
+
+// pick the voice that renders a motif's notes
+func newNoteRenderer(m Motif, opts RenderOptions) (noteRenderer, error) {
+	voice := opts.WaveForm
+	tuning, err := getTuning(opts.Tuning, opts.ReferencePitch, opts.KeyboardMapping, m.Key)
+	if err != nil {
+		return nil, err
+	}
+	_, fm := fmPresets[voice]
+	sampled := voice == soundFontVoice && soundFont != nil
+	// oscillator waveforms, the default one included, are rendered as one continuous voice so pitch can move between notes
+	if !fm && !sampled && !m.Percussion {
+		return newContinuousOscillator(m, opts, tuning).renderNote, nil
+	}
+	if !m.Percussion && hasPitchModulation(m, opts) {
+		fmt.Println("WARNING:", voice, "ignores pitch bend, vibrato and glide, they only sound on oscillator waveforms")
+	}
+	return func(idx int) []float64 {
+		n := m.Notes[idx]
+		start, end := getNoteSpan(n, m)
+		if n.Value < 0 || end <= start {
+			return nil
+		}
+		ds := getSamplesInSeconds(end - start)
+		var buf *audio.FloatBuffer
+		switch {
+		// drum tracks are unpitched so they don't go through the pitch table
+		case m.Percussion:
+			if verbose {
+				fmt.Println("AUDIO DRUM DATA:", getMIDINote(n.Value), "secs:", ds)
+			}
+			buf = generateDrumAudio(getMIDINote(n.Value), ds)
+		case sampled:
+			if verbose {
+				fmt.Println("AUDIO NOTE DATA:", n.Name, n.Octave, n.Pitch, "secs:", ds)
+			}
+			buf = soundFont.generateAudio(m.Bank, m.Program, getMIDINote(n.Value), defaultVelocity, ds)
+		default:
+			freq := tuning.getFrequency(getMIDINote(n.Value))
+			if verbose {
+				fmt.Println("AUDIO NOTE DATA:", n.Name, n.Octave, n.Pitch, "freq:", freq, "secs:", ds)
+			}
+			buf = generateAudioFrequency(freq, ds, voice)
+		}
+		return buf.Data
+	}, nil
+}
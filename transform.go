@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TransformSpec : declarative description of one motif transformation in a chain
+// like effects a chain is a []TransformSpec so it can be sent and saved as JSON
+type TransformSpec struct {
+	Type    string             `json:"type"`
+	Params  map[string]float64 `json:"params,omitempty"`
+	Options map[string]string  `json:"options,omitempty"`
+}
+
+// default parameters of each transformation type
+var transformDefaults = map[string]map[string]float64{
+	"transpose":  {"semitones": 0},
+	"diatonic":   {"steps": 0},               // transpose by steps of the motif's scale
+	"invert":     {"axis": 0, "diatonic": 0}, // axis is a note value, 0 for the first note
+	"retrograde": {},                         // play the motif backwards
+	"augment":    {"factor": 2},              // stretch durations by the factor
+	"diminish":   {"factor": 2},              // shrink durations by the factor
+	"rotate":     {"steps": 1},               // move the pitches steps notes earlier, keeping the rhythm
+	"snap":       {},                         // move notes outside a scale onto it
+}
+
+// options given as words that each transformation type takes
+var transformOptions = map[string][]string{
+	"snap": {"key", "mode", "direction"}, // the motif's key and mode unless given, direction is nearest, up or down
+}
+
+// directions notes can be snapped to a scale in
+var snapDirections = map[string]int{"nearest": 0, "up": 1, "down": -1}
+
+// look up a transformation parameter, falling back to its default
+func (s TransformSpec) param(name string) float64 {
+	if v, ok := s.Params[name]; ok {
+		return v
+	}
+	return transformDefaults[s.Type][name]
+}
+
+// take a transform chain string and return its specs
+// transformations are written like effects, e.g. "transpose:semitones=-3;retrograde;augment:factor=2"
+// a chain can also be given as JSON
+func parseTransformChain(s string) ([]TransformSpec, error) {
+	var chain []TransformSpec
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return chain, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &chain); err != nil {
+			return nil, err
+		}
+		return chain, validateTransformChain(chain)
+	}
+	for _, t := range strings.Split(s, ";") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		name, params, options, err := parseChainStep(t)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, TransformSpec{Type: name, Params: params, Options: options})
+	}
+	return chain, validateTransformChain(chain)
+}
+
+// take a transform chain string or the path of a JSON file and return its specs
+func loadTransformChain(s string) ([]TransformSpec, error) {
+	if strings.HasSuffix(strings.ToLower(s), ".json") && fileExists(s) {
+		chain, err := ioutil.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		return parseTransformChain(string(chain))
+	}
+	return parseTransformChain(s)
+}
+
+func validateTransformChain(chain []TransformSpec) error {
+	for _, spec := range chain {
+		defaults, ok := transformDefaults[spec.Type]
+		if !ok {
+			return fmt.Errorf("unknown transformation %v", spec.Type)
+		}
+		for name := range spec.Params {
+			if _, ok := defaults[name]; !ok {
+				return fmt.Errorf("unknown %v parameter %v", spec.Type, name)
+			}
+		}
+		for name := range spec.Options {
+			if Index(transformOptions[spec.Type], name) < 0 {
+				return fmt.Errorf("unknown %v option %v", spec.Type, name)
+			}
+		}
+		if (spec.Type == "augment" || spec.Type == "diminish") && spec.param("factor") <= 0 {
+			return fmt.Errorf("%v factor must be positive", spec.Type)
+		}
+		if spec.Type == "snap" {
+			if _, ok := snapDirections[spec.option("direction")]; !ok {
+				return fmt.Errorf("unknown snap direction %v", spec.Options["direction"])
+			}
+			if key := spec.option("key"); key != "" && Index(config.Notes, key[:1]) < 0 {
+				return fmt.Errorf("unknown key %v", key)
+			}
+			if _, err := newScale(spec.option("key"), spec.option("mode")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// look up a transformation option, snapping defaults to the nearest note
+func (s TransformSpec) option(name string) string {
+	if v := strings.ToLower(strings.TrimSpace(s.Options[name])); v != "" {
+		return v
+	}
+	if name == "direction" {
+		return "nearest"
+	}
+	return ""
+}
+
+// describe a chain the way parseTransformChain reads it
+func formatTransformChain(chain []TransformSpec) string {
+	var transforms []string
+	for _, spec := range chain {
+		transforms = append(transforms, formatChainStep(spec.Type, spec.Params, spec.Options))
+	}
+	return strings.Join(transforms, ";")
+}
+
+// run each motif through a transform chain
+func applyTransformChains(motifs []Motif, chain []TransformSpec) ([]Motif, error) {
+	if len(chain) == 0 {
+		return motifs, nil
+	}
+	var transformed []Motif
+	for _, m := range motifs {
+		t, err := applyTransformChain(m, chain)
+		if err != nil {
+			return nil, err
+		}
+		transformed = append(transformed, t)
+	}
+	return transformed, nil
+}
+
+// take a motif and return a transformed copy with its relative fields recomputed
+func applyTransformChain(m Motif, chain []TransformSpec) (Motif, error) {
+	if err := validateTransformChain(chain); err != nil {
+		return m, err
+	}
+	if err := checkMotifNotes(m); err != nil {
+		return m, err
+	}
+	// transforms move the notes that sound, they are written out again afterwards
+	m = joinTiedNotes(m)
+	var err error
+	for _, spec := range chain {
+		if m, err = applyTransform(m, spec); err != nil {
+			return m, fmt.Errorf("%v: %v", spec.Type, err)
+		}
+	}
+	m.computeRelativeFields()
+	return m, nil
+}
+
+func applyTransform(m Motif, spec TransformSpec) (Motif, error) {
+	switch spec.Type {
+	case "transpose":
+		return transposeMotif(m, int(spec.param("semitones")))
+	case "diatonic":
+		return transposeMotifDiatonic(m, int(spec.param("steps")))
+	case "invert":
+		return invertMotif(m, int(spec.param("axis")), spec.param("diatonic") != 0)
+	case "retrograde":
+		return retrogradeMotif(m), nil
+	case "augment":
+		return scaleMotifTime(m, spec.param("factor"))
+	case "diminish":
+		return scaleMotifTime(m, 1/spec.param("factor"))
+	case "rotate":
+		return rotateMotif(m, int(spec.param("steps"))), nil
+	default:
+		return snapMotif(m, spec.option("key"), spec.option("mode"), snapDirections[spec.option("direction")])
+	}
+}
+
+// make sure the notes of a motif can be worked on, checking them as they are written
+func checkMotifNotes(m Motif) error {
+	return validateMotifs([]Motif{notateMotif(m)})
+}
+
+// whether a note value is in the range of the configured pitches
+func isValidNoteValue(value int) bool {
+	return value >= 1 && value <= len(config.Pitches)
+}
+
+// take motif and return a copy with each sounding note moved by f
+// percussion notes are drum keys rather than pitches so they are left alone
+func mapMotifPitches(m Motif, f func(value int) int) (Motif, error) {
+	if m.Percussion {
+		return m, nil
+	}
+	notes := make([]MotifNote, len(m.Notes))
+	for idx, n := range m.Notes {
+		if n.Value >= 0 {
+			value := f(n.Value)
+			if !isValidNoteValue(value) {
+				return m, fmt.Errorf("%v moves out of range", n.Pitch)
+			}
+			n.Note = newNote(value, n.Duration)
+		}
+		notes[idx] = n
+	}
+	m.Notes = notes
+	return m, nil
+}
+
+// the value of the first sounding note, -1 when the motif is all rests
+func getFirstNoteValue(m Motif) int {
+	for _, n := range m.Notes {
+		if n.Value >= 0 {
+			return n.Value
+		}
+	}
+	return -1
+}
+
+func transposeMotif(m Motif, semitones int) (Motif, error) {
+	t, err := mapMotifPitches(m, func(value int) int {
+		return value + semitones
+	})
+	if err == nil && !m.Percussion && strings.TrimSpace(m.Key) != "" {
+		t.Key = config.Notes[floorMod(getPitchClass(m.Key)+semitones, 12)]
+	}
+	return t, err
+}
+
+// move notes by steps of the motif's scale, notes outside it keep their distance above the degree below them
+func transposeMotifDiatonic(m Motif, steps int) (Motif, error) {
+	scale := m.getScale()
+	return mapMotifPitches(m, func(value int) int {
+		pos, offset := scale.getScalePosition(value)
+		return scale.getScaleValue(pos+steps, offset)
+	})
+}
+
+// mirror notes around an axis note, in semitones or in steps of the motif's scale
+func invertMotif(m Motif, axis int, diatonic bool) (Motif, error) {
+	if axis == 0 {
+		axis = getFirstNoteValue(m)
+	}
+	if axis < 0 {
+		return m, nil
+	}
+	if !diatonic {
+		return mapMotifPitches(m, func(value int) int {
+			return 2*axis - value
+		})
+	}
+	scale := m.getScale()
+	axisPos, _ := scale.getScalePosition(axis)
+	return mapMotifPitches(m, func(value int) int {
+		pos, offset := scale.getScalePosition(value)
+		return scale.getScaleValue(2*axisPos-pos, -offset)
+	})
+}
+
+// units from the start of the motif to the end of its last note
+func getMotifEnd(m Motif) int {
+	end := 0
+	for _, n := range m.Notes {
+		if e := n.StartingBeat - 1 + n.Duration; e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// play the motif backwards, notes end where they started from the end of the motif
+func retrogradeMotif(m Motif) Motif {
+	end := getMotifEnd(m)
+	notes := make([]MotifNote, 0, len(m.Notes))
+	for idx := len(m.Notes) - 1; idx >= 0; idx-- {
+		n := m.Notes[idx]
+		n.StartingBeat = end - (n.StartingBeat - 1 + n.Duration) + 1
+		notes = append(notes, n)
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].StartingBeat < notes[j].StartingBeat
+	})
+	m.Notes = notes
+	m.PitchBend = reverseControlCurve(m.PitchBend, end)
+	m.Modulation = reverseControlCurve(m.Modulation, end)
+	return m
+}
+
+// take a controller curve and return it played backwards from the end of the motif
+// each point holds until the next one so the spans between points are reversed rather than the points
+func reverseControlCurve(points []ControlPoint, end int) []ControlPoint {
+	if len(points) == 0 {
+		return nil
+	}
+	var reversed []ControlPoint
+	// spans count units from 0, the span before the first point holds the controller at rest
+	for idx := len(points) - 1; idx >= -1; idx-- {
+		from, to, value := 0, end, 0.0
+		if idx >= 0 {
+			from, value = points[idx].Beat-1, points[idx].Value
+		}
+		if idx+1 < len(points) {
+			to = points[idx+1].Beat - 1
+		}
+		if to > end {
+			to = end
+		}
+		if from >= to {
+			continue
+		}
+		reversed = append(reversed, ControlPoint{Beat: end - to + 1, Value: value})
+	}
+	return reversed
+}
+
+// stretch (factor > 1) or shrink (factor < 1) the rhythm of a motif
+func scaleMotifTime(m Motif, factor float64) (Motif, error) {
+	scale := func(units int) int {
+		return int(math.Round(float64(units) * factor))
+	}
+	notes := make([]MotifNote, len(m.Notes))
+	for idx, n := range m.Notes {
+		// the start and end are scaled rather than the duration so notes stay back to back
+		start, end := scale(n.StartingBeat-1), scale(n.StartingBeat-1+n.Duration)
+		if end <= start {
+			return m, fmt.Errorf("note at beat %v is too short", n.StartingBeat)
+		}
+		n.Note = newNote(n.Value, end-start)
+		n.StartingBeat = start + 1
+		notes[idx] = n
+	}
+	scalePoints := func(points []ControlPoint) []ControlPoint {
+		var scaled []ControlPoint
+		for _, p := range points {
+			scaled = append(scaled, ControlPoint{Beat: scale(p.Beat-1) + 1, Value: p.Value})
+		}
+		return scaled
+	}
+	m.Notes = notes
+	m.PitchBend = scalePoints(m.PitchBend)
+	m.Modulation = scalePoints(m.Modulation)
+	return m, nil
+}
+
+// move the pitches of the sounding notes steps notes earlier, wrapping the first ones round to the end
+// the rhythm and rests stay where they are
+func rotateMotif(m Motif, steps int) Motif {
+	var sounding []int
+	for idx, n := range m.Notes {
+		if n.Value >= 0 {
+			sounding = append(sounding, idx)
+		}
+	}
+	if len(sounding) == 0 {
+		return m
+	}
+	notes := make([]MotifNote, len(m.Notes))
+	copy(notes, m.Notes)
+	for k, idx := range sounding {
+		from := m.Notes[sounding[floorMod(k+steps, len(sounding))]]
+		notes[idx].Note = newNote(from.Value, notes[idx].Duration)
+	}
+	m.Notes = notes
+	return m
+}
+
+// move the notes of a motif that are outside a scale onto it, up or down or to the nearest note with ties going down
+// the scale is the motif's own unless a key or mode is given and a motif snapped to a given key takes it on
+func snapMotif(m Motif, key string, mode string, direction int) (Motif, error) {
+	scale := m.getScale()
+	if key != "" || mode != "" {
+		if key == "" {
+			key = config.Notes[scale.Tonic]
+		}
+		if mode == "" {
+			mode = scale.Mode
+		}
+		var err error
+		if scale, err = newScale(key, mode); err != nil {
+			return m, err
+		}
+	}
+	snapped, err := mapMotifPitches(m, func(value int) int {
+		return scale.snap(value, direction)
+	})
+	if err != nil || m.Percussion || (key == "" && mode == "") {
+		return snapped, err
+	}
+	snapped.Key = config.Notes[scale.Tonic]
+	snapped.Mode = scale.Mode
+	snapped.KeyConfidence = 1
+	snapped.KeySource = keySourceUser
+	return snapped, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultTuningName string = "12-tet"
+const justTuningName string = "just"
+const defaultReferencePitch float64 = 440
+
+// MIDI note numbers used to anchor tunings
+const midiMiddleC int = 60
+const midiA4 int = 69
+
+// 5-limit just intonation ratios for each semitone above the tonic
+var justRatios = [][2]float64{
+	{16, 15}, {9, 8}, {6, 5}, {5, 4}, {4, 3}, {45, 32}, {3, 2}, {8, 5}, {5, 3}, {9, 5}, {15, 8}, {2, 1},
+}
+
+// Tuning : maps MIDI note numbers to frequencies
+// a tuning is a scale (Degrees) laid out across the keyboard from MiddleNote
+// and pinned to a frequency at ReferenceNote, the same model Scala uses
+type Tuning struct {
+	Name          string    `json:"name"`
+	Degrees       []float64 `json:"degrees"`       // cents of scale degrees 1..n, the last one is the period
+	MiddleNote    int       `json:"middleNote"`    // MIDI note of scale degree 0
+	ReferenceNote int       `json:"referenceNote"` // MIDI note tuned to Reference
+	Reference     float64   `json:"reference"`     // frequency of ReferenceNote in Hz
+	Mapping       []int     `json:"mapping"`       // keyboard mapping, -1 for unmapped keys, empty for a linear mapping
+	OctaveDegree  int       `json:"octaveDegree"`  // scale degree the keyboard mapping repeats at
+}
+
+// floor division that rounds towards negative infinity
+func floorDiv(a int, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// remainder of floorDiv, always between 0 and b
+func floorMod(a int, b int) int {
+	return a - floorDiv(a, b)*b
+}
+
+// cents of a scale degree, which may be negative or beyond the period
+func (t Tuning) getDegreeCents(degree int) float64 {
+	n := len(t.Degrees)
+	periods := floorDiv(degree, n)
+	step := degree - periods*n
+	cents := float64(periods) * t.Degrees[n-1]
+	if step > 0 {
+		cents += t.Degrees[step-1]
+	}
+	return cents
+}
+
+// cents of a MIDI note relative to MiddleNote, false if the key is unmapped
+func (t Tuning) getCents(key int) (float64, bool) {
+	if len(t.Degrees) == 0 {
+		return 0, false
+	}
+	offset := key - t.MiddleNote
+	if len(t.Mapping) == 0 {
+		return t.getDegreeCents(offset), true
+	}
+	size := len(t.Mapping)
+	repeats := floorDiv(offset, size)
+	degree := t.Mapping[offset-repeats*size]
+	if degree < 0 {
+		return 0, false
+	}
+	octaveDegree := t.OctaveDegree
+	if octaveDegree == 0 {
+		octaveDegree = len(t.Degrees)
+	}
+	return float64(repeats)*t.getDegreeCents(octaveDegree) + t.getDegreeCents(degree), true
+}
+
+// frequency of a MIDI note in Hz, 0 for rests and unmapped keys
+func (t Tuning) getFrequency(key int) float64 {
+	if key < 0 {
+		return 0
+	}
+	cents, ok := t.getCents(key)
+	if !ok {
+		return 0
+	}
+	refCents, ok := t.getCents(t.ReferenceNote)
+	if !ok {
+		// an unmapped reference note still sits on the linear layout
+		refCents = t.getDegreeCents(t.ReferenceNote - t.MiddleNote)
+	}
+	return t.Reference * math.Pow(2, (cents-refCents)/1200)
+}
+
+// Tuning factory for N equal divisions of the octave with A4 at reference Hz
+func newEDOTuning(divisions int, reference float64) Tuning {
+	var degrees []float64
+	for i := 1; i <= divisions; i++ {
+		degrees = append(degrees, 1200*float64(i)/float64(divisions))
+	}
+	return Tuning{
+		Name:          fmt.Sprintf("%d-edo", divisions),
+		Degrees:       degrees,
+		MiddleNote:    midiMiddleC,
+		ReferenceNote: midiA4,
+		Reference:     reference,
+	}
+}
+
+// Tuning factory for 5-limit just intonation built on the tonic of key
+// the tonic keeps its equal tempered frequency so A4 = reference still anchors the tuning
+func newJustTuning(key string, reference float64) Tuning {
+	var degrees []float64
+	for _, r := range justRatios {
+		degrees = append(degrees, 1200*math.Log2(r[0]/r[1]))
+	}
+	tonic := midiMiddleC + getPitchClass(key)
+	return Tuning{
+		Name:          justTuningName,
+		Degrees:       degrees,
+		MiddleNote:    tonic,
+		ReferenceNote: tonic,
+		Reference:     reference * math.Pow(2, float64(tonic-midiA4)/12),
+	}
+}
+
+// read the lines of a Scala file that aren't comments
+func readScalaLines(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// take a Scala .scl pitch value (cents when it has a period, a ratio otherwise) and return cents
+func parseScalaPitch(s string) (float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, errors.New("empty pitch")
+	}
+	s = fields[0]
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+	parts := strings.SplitN(s, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	den := 1.0
+	if len(parts) == 2 {
+		if den, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return 0, err
+		}
+	}
+	if num <= 0 || den <= 0 {
+		return 0, fmt.Errorf("invalid ratio %v", s)
+	}
+	return 1200 * math.Log2(num/den), nil
+}
+
+// take a Scala .scl file on disk and return a Tuning with A4 at reference Hz
+// see http://www.huygens-fokker.org/scala/scl_format.html
+func parseScalaFile(filePath string, reference float64) (Tuning, error) {
+	t := Tuning{}
+	lines, err := readScalaLines(filePath)
+	if err != nil {
+		return t, err
+	}
+	if len(lines) < 2 {
+		return t, errors.New("Scala file is missing its header")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil || count < 1 {
+		return t, errors.New("Scala file has an invalid note count")
+	}
+	if len(lines)-2 < count {
+		return t, fmt.Errorf("Scala file lists %d of %d pitches", len(lines)-2, count)
+	}
+	for _, l := range lines[2 : 2+count] {
+		cents, err := parseScalaPitch(l)
+		if err != nil {
+			return t, err
+		}
+		t.Degrees = append(t.Degrees, cents)
+	}
+	t.Name = strings.TrimSpace(lines[0])
+	if t.Name == "" {
+		t.Name = filepath.Base(filePath)
+	}
+	t.MiddleNote = midiMiddleC
+	t.ReferenceNote = midiA4
+	t.Reference = reference
+	return t, nil
+}
+
+// take a Scala .kbm keyboard mapping file on disk and apply it to the tuning
+// see http://www.huygens-fokker.org/scala/help.htm#mappings
+func parseKeyboardMappingFile(filePath string, t *Tuning) error {
+	lines, err := readScalaLines(filePath)
+	if err != nil {
+		return err
+	}
+	var values []string
+	for _, l := range lines {
+		if f := strings.Fields(l); len(f) > 0 {
+			values = append(values, f[0])
+		}
+	}
+	if len(values) < 7 {
+		return errors.New("keyboard mapping file is missing its header")
+	}
+	header := make([]int, 7)
+	for i, v := range values[:7] {
+		if i == 5 {
+			continue
+		}
+		if header[i], err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid keyboard mapping value %v", v)
+		}
+	}
+	reference, err := strconv.ParseFloat(values[5], 64)
+	if err != nil || reference <= 0 {
+		return errors.New("keyboard mapping has an invalid reference frequency")
+	}
+	size := header[0]
+	var mapping []int
+	for i := 0; i < size; i++ {
+		// missing trailing entries and x are unmapped keys
+		degree := -1
+		if 7+i < len(values) && values[7+i] != "x" {
+			if degree, err = strconv.Atoi(values[7+i]); err != nil {
+				return fmt.Errorf("invalid keyboard mapping degree %v", values[7+i])
+			}
+		}
+		mapping = append(mapping, degree)
+	}
+	t.Mapping = mapping
+	t.MiddleNote = header[3]
+	t.ReferenceNote = header[4]
+	t.Reference = reference
+	t.OctaveDegree = header[6]
+	return nil
+}
+
+// resolve the tuning selected for a render
+// name is 12-tet, just, <n>-edo or the path of a Scala .scl file
+func getTuning(name string, reference float64, keyboardMapping string, key string) (Tuning, error) {
+	if reference <= 0 {
+		reference = defaultReferencePitch
+	}
+	var t Tuning
+	var err error
+	filePath := strings.TrimSpace(name)
+	name = strings.ToLower(filePath)
+	switch {
+	case name == "" || name == defaultTuningName || name == "equal":
+		t = newEDOTuning(12, reference)
+	case name == justTuningName:
+		t = newJustTuning(key, reference)
+	case strings.HasSuffix(name, "edo") || strings.HasSuffix(name, "tet"):
+		divisions, convErr := strconv.Atoi(strings.TrimRight(strings.TrimSuffix(strings.TrimSuffix(name, "edo"), "tet"), "-"))
+		if convErr != nil || divisions < 1 {
+			return t, fmt.Errorf("invalid equal division tuning %v", name)
+		}
+		t = newEDOTuning(divisions, reference)
+	case strings.HasSuffix(name, ".scl"):
+		if t, err = parseScalaFile(filePath, reference); err != nil {
+			return t, err
+		}
+	default:
+		return t, fmt.Errorf("unknown tuning %v", name)
+	}
+	if keyboardMapping != "" {
+		err = parseKeyboardMappingFile(keyboardMapping, &t)
+	}
+	return t, err
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pentatonic scale with a just major second, third and sixth and a cents fifth
+const testScala = `! pentatonic.scl
+!
+Just pentatonic
+ 5
+!
+ 9/8
+ 5/4
+ 701.955
+ 5/3 ! a ratio can be followed by a comment
+ 2/1
+`
+
+// the pentatonic scale on the white keys C D E G A with A4 at 440 Hz
+const testKeyboardMapping = `! pentatonic.kbm
+! size of map
+12
+! first and last MIDI notes to retune
+0
+127
+! middle note where degree 0 is mapped
+60
+! reference note and its frequency
+69
+440.0
+! scale degree of the formal octave
+5
+! mapping
+0
+x
+1
+x
+2
+x
+x
+3
+x
+4
+x
+x
+`
+
+// write files to a temporary dir and return their paths and a func removing them
+func writeTestFiles(t *testing.T, files map[string]string) (map[string]string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tuning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	for name, content := range files {
+		paths[name] = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(paths[name], []byte(content), 0666); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return paths, func() { os.RemoveAll(dir) }
+}
+
+func TestParseScalaPitch(t *testing.T) {
+	tests := []struct {
+		pitch string
+		want  float64
+		err   bool
+	}{
+		{"100.0", 100, false},
+		{" 701.955 ! fifth", 701.955, false},
+		{"3/2", 701.955, false},
+		{"5/4", 386.314, false},
+		{"2", 1200, false},
+		{"-5.5", -5.5, false},
+		{"0/1", 0, true},
+		{"3/0", 0, true},
+		{"fifth", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseScalaPitch(tt.pitch)
+		if (err != nil) != tt.err {
+			t.Errorf("parseScalaPitch(%q) error = %v, want error %v", tt.pitch, err, tt.err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("parseScalaPitch(%q) = %v, want %v", tt.pitch, got, tt.want)
+		}
+	}
+}
+
+func TestParseScalaFile(t *testing.T) {
+	paths, cleanUp := writeTestFiles(t, map[string]string{
+		"pentatonic.scl": testScala,
+		"short.scl":      "Too short\n 3\n 100.0\n 1200.0\n",
+		"count.scl":      "No count\n five\n",
+	})
+	defer cleanUp()
+	tuning, err := parseScalaFile(paths["pentatonic.scl"], 440)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{203.910, 386.314, 701.955, 884.359, 1200}
+	if tuning.Name != "Just pentatonic" || len(tuning.Degrees) != len(want) {
+		t.Fatalf("got %v with degrees %v, want Just pentatonic with %v", tuning.Name, tuning.Degrees, want)
+	}
+	for i, cents := range want {
+		if math.Abs(tuning.Degrees[i]-cents) > 0.001 {
+			t.Errorf("degree %d = %v, want %v", i+1, tuning.Degrees[i], cents)
+		}
+	}
+	for _, name := range []string{"short.scl", "count.scl"} {
+		if _, err := parseScalaFile(paths[name], 440); err == nil {
+			t.Errorf("parseScalaFile(%v) didn't fail", name)
+		}
+	}
+}
+
+func TestScalaFrequencies(t *testing.T) {
+	paths, cleanUp := writeTestFiles(t, map[string]string{"pentatonic.scl": testScala, "pentatonic.kbm": testKeyboardMapping})
+	defer cleanUp()
+	tests := []struct {
+		kbm  string
+		key  int
+		want float64
+	}{
+		// laid out linearly from middle C the scale repeats every 5 keys and A4 (degree 9) is 440 Hz
+		{"", 69, 440},
+		{"", 60, 132},
+		{"", 61, 148.5},
+		{"", 65, 264},
+		{"", 55, 66},
+		// mapped onto the white keys C4 is a just major sixth below A4
+		{"pentatonic.kbm", 69, 440},
+		{"pentatonic.kbm", 60, 264},
+		{"pentatonic.kbm", 62, 297},
+		{"pentatonic.kbm", 64, 330},
+		{"pentatonic.kbm", 67, 396},
+		{"pentatonic.kbm", 72, 528},
+		{"pentatonic.kbm", 57, 220},
+		// keys left out of the mapping are silent
+		{"pentatonic.kbm", 61, 0},
+	}
+	for _, tt := range tests {
+		kbm := ""
+		if tt.kbm != "" {
+			kbm = paths[tt.kbm]
+		}
+		tuning, err := getTuning(paths["pentatonic.scl"], 440, kbm, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tuning.getFrequency(tt.key); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%v key %d = %v Hz, want %v", tt.kbm, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeyboardMappingFile(t *testing.T) {
+	paths, cleanUp := writeTestFiles(t, map[string]string{
+		"pentatonic.kbm": testKeyboardMapping,
+		"header.kbm":     "12\n0\n127\n60\n",
+		"reference.kbm":  "12\n0\n127\n60\n69\nA\n12\n",
+	})
+	defer cleanUp()
+	var tuning Tuning
+	if err := parseKeyboardMappingFile(paths["pentatonic.kbm"], &tuning); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, -1, 1, -1, 2, -1, -1, 3, -1, 4, -1, -1}
+	if len(tuning.Mapping) != len(want) {
+		t.Fatalf("got mapping %v, want %v", tuning.Mapping, want)
+	}
+	for i := range want {
+		if tuning.Mapping[i] != want[i] {
+			t.Fatalf("got mapping %v, want %v", tuning.Mapping, want)
+		}
+	}
+	if tuning.MiddleNote != 60 || tuning.ReferenceNote != 69 || tuning.Reference != 440 || tuning.OctaveDegree != 5 {
+		t.Errorf("got middle %d reference %d at %v Hz and octave degree %d", tuning.MiddleNote, tuning.ReferenceNote, tuning.Reference, tuning.OctaveDegree)
+	}
+	for _, name := range []string{"header.kbm", "reference.kbm"} {
+		if err := parseKeyboardMappingFile(paths[name], &Tuning{}); err == nil {
+			t.Errorf("parseKeyboardMappingFile(%v) didn't fail", name)
+		}
+	}
+}
+
+func TestEDOFrequency(t *testing.T) {
+	tests := []struct {
+		divisions int
+		reference float64
+		key       int
+		want      float64
+	}{
+		{12, 440, 69, 440},
+		{12, 440, 60, 261.6256},
+		{12, 440, 21, 27.5},
+		{12, 440, 108, 4186.009},
+		{12, 432, 69, 432},
+		{12, 432, 57, 216},
+		// every key is a step of the division, so A5 is 19 keys above A4 in 19-EDO
+		{19, 440, 70, 456.3482},
+		{19, 440, 88, 880},
+		{19, 440, 60, 316.8542},
+		{24, 440, 70, 452.893},
+		{24, 440, 45, 220},
+		{5, 440, 74, 880},
+		// rests have no pitch
+		{12, 440, -1, 0},
+	}
+	for _, tt := range tests {
+		tuning := newEDOTuning(tt.divisions, tt.reference)
+		if got := tuning.getFrequency(tt.key); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%d-EDO at %v Hz key %d = %v Hz, want %v", tt.divisions, tt.reference, tt.key, got, tt.want)
+		}
+	}
+}
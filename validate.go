@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MotifProblem : something wrong with a motif, the note it was found on and why
+type MotifProblem struct {
+	Motif  string `json:"motif,omitempty"` // id of the motif, its name or position when it has neither
+	Note   int    `json:"note"`            // index of the note, -1 for a problem with the motif itself
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (p MotifProblem) String() string {
+	where := p.Motif
+	if p.Note >= 0 {
+		where = fmt.Sprintf("%v note %d", where, p.Note)
+	}
+	return fmt.Sprintf("%v %v: %v", strings.TrimSpace(where), p.Field, p.Reason)
+}
+
+// MotifValidationError : motifs that can't be converted and every problem found with them
+type MotifValidationError struct {
+	Problems []MotifProblem
+}
+
+func (e *MotifValidationError) Error() string {
+	if len(e.Problems) == 0 {
+		return "invalid motif"
+	}
+	return fmt.Sprintf("invalid motifs (%d problems), %v", len(e.Problems), e.Problems[0])
+}
+
+// Validate : check the notes of a motif as they are written can be converted
+// pitches out of the range of config.Pitches, notes without a duration, notes starting before the first beat
+// or before the note listed ahead of them, notes held over a bar line instead of tied across it,
+// rests over sounding notes and a pitch sounding over itself are all problems
+func (m Motif) Validate() []MotifProblem {
+	var problems []MotifProblem
+	add := func(note int, field string, reason string, args ...interface{}) {
+		problems = append(problems, MotifProblem{Note: note, Field: field, Reason: fmt.Sprintf(reason, args...)})
+	}
+	if m.Tempo.Units <= 0 {
+		add(-1, "tempo", "tempo %d is not positive", m.Tempo.Units)
+	}
+	ts := m.TimeSignature
+	if ts.Beat <= 0 || ts.Unit <= 0 || ts.Unit&(ts.Unit-1) != 0 {
+		add(-1, "timeSignature", "time signature %d/%d needs a positive number of beats of a power of two", ts.Beat, ts.Unit)
+		// bar lines can't be found without a time signature
+		ts = TimeSignature{}
+	}
+	unitsPerBar := getUnitsPerBar(ts)
+
+	var sounding []int // indexes of the notes checked so far that are still sounding
+	for idx, n := range m.Notes {
+		switch {
+		case n.Value == 0:
+			add(idx, "value", "note value 0 is below c0, the lowest pitch")
+		case n.Value < -1:
+			add(idx, "value", "note value %d is not a pitch, values start at 1 and rests are -1", n.Value)
+		case n.Value > len(config.Pitches):
+			add(idx, "value", "note value %d is above the highest pitch %d", n.Value, len(config.Pitches))
+		}
+		if n.Duration <= 0 {
+			add(idx, "duration", "duration %d is not positive", n.Duration)
+		}
+		if n.StartingBeat < 1 {
+			add(idx, "startingBeat", "starting beat %d is before the first beat", n.StartingBeat)
+			continue
+		}
+		if idx > 0 && n.StartingBeat < m.Notes[idx-1].StartingBeat {
+			add(idx, "startingBeat", "starts at %d before note %d at %d", n.StartingBeat, idx-1, m.Notes[idx-1].StartingBeat)
+		}
+		if n.Duration <= 0 {
+			continue
+		}
+		start, end := n.StartingBeat, n.StartingBeat+n.Duration
+		// bars are counted from the start of the track a motif was cut from
+		if from := m.Offset + start - 1; unitsPerBar > 0 && from/unitsPerBar != (from+n.Duration-1)/unitsPerBar {
+			add(idx, "duration", "held over the bar line at bar %d, write it as notes tied across the bar line", from/unitsPerBar+2)
+		}
+		// notes are in order so one that has stopped can't overlap the notes after it
+		held := sounding[:0]
+		for _, k := range sounding {
+			o := m.Notes[k]
+			if o.StartingBeat+o.Duration <= start {
+				continue
+			}
+			held = append(held, k)
+			if o.StartingBeat >= end {
+				continue
+			}
+			switch {
+			case n.Value == -1 && o.Value != -1:
+				add(idx, "startingBeat", "rest overlaps note %d", k)
+			case n.Value != -1 && o.Value == -1:
+				add(idx, "startingBeat", "overlaps rest %d", k)
+			case n.Value == o.Value && n.Value != -1:
+				add(idx, "startingBeat", "overlaps note %d of the same pitch", k)
+			}
+		}
+		sounding = append(held, idx)
+	}
+	return problems
+}
+
+// the problems of a MotifValidationError, nil for any other error
+func getMotifProblems(err error) []MotifProblem {
+	var invalid *MotifValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Problems
+	}
+	return nil
+}
+
+// print the problems of a validation
+func printMotifProblems(problems []MotifProblem) {
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+}
+
+// validate motifs and return a MotifValidationError with their problems when any of them has one
+// problems are labelled with the motif they were found on
+func validateMotifs(motifs []Motif) error {
+	var problems []MotifProblem
+	for idx, m := range motifs {
+		label := m.ID
+		if label == "" {
+			label = m.Name
+		}
+		if label == "" {
+			label = fmt.Sprintf("motif %d", idx+1)
+		}
+		for _, p := range m.Validate() {
+			p.Motif = label
+			problems = append(problems, p)
+		}
+	}
+	if problems == nil {
+		return nil
+	}
+	return &MotifValidationError{Problems: problems}
+}
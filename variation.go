@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// chance of each note being ornamented unless it is given
+const defaultOrnamentChance float64 = 0.25
+
+// VariationOptions : how many variations of a seed motif to generate and what they learn from
+type VariationOptions struct {
+	Count    int     // variations generated after the seed, 0 to turn generating off
+	Seed     int64   // the same seed always generates the same variations
+	Ornament float64 // chance from 0 to 1 of each note getting an ornament
+	Corpus   []Motif // motifs the chain learns from as well as the seed
+}
+
+// variationStep : a sounding note and the move to the next one, the states of the Markov chain
+type variationStep struct {
+	interval int // semitones to the next note
+	duration int
+	rest     int // units of rest after the note
+}
+
+// markovChain : the steps heard after each step in the motifs it learned from
+// followers are kept in the order they were heard, repeats and all, so picking one is weighted by how often it was heard
+type markovChain struct {
+	followers map[variationStep][]variationStep
+	steps     []variationStep // every step heard, for when a step has no followers
+}
+
+func newMarkovChain() *markovChain {
+	return &markovChain{followers: map[variationStep][]variationStep{}}
+}
+
+// the steps of a motif's melody, the last note has nowhere to go so it makes no step
+func getVariationSteps(m Motif) []variationStep {
+	sounding, _ := getMotifSteps(m)
+	var steps []variationStep
+	for idx := 0; idx+1 < len(sounding); idx++ {
+		n, next := sounding[idx], sounding[idx+1]
+		steps = append(steps, variationStep{
+			interval: next.Value - n.Value,
+			duration: n.Duration,
+			rest:     next.StartingBeat - (n.StartingBeat + n.Duration),
+		})
+	}
+	return steps
+}
+
+// learn the steps of a motif, percussion has no melody to learn
+func (c *markovChain) learn(m Motif) {
+	if m.Percussion {
+		return
+	}
+	steps := getVariationSteps(joinTiedNotes(m))
+	for idx, s := range steps {
+		c.steps = append(c.steps, s)
+		if idx+1 < len(steps) {
+			c.followers[s] = append(c.followers[s], steps[idx+1])
+		}
+	}
+}
+
+// pick the step after another
+func (c *markovChain) next(s variationStep, rng *rand.Rand) variationStep {
+	followers := c.followers[s]
+	if len(followers) == 0 {
+		followers = c.steps
+	}
+	return followers[rng.Intn(len(followers))]
+}
+
+// take a seed motif and walk the chain from its first note and step for as many notes as it has
+// the variation starts where the seed does, the last note keeps the seed's last duration and steps that would leave the range of notes turn round
+// notes are kept to the seed's scale so the intervals of a corpus or a chromatic step don't wander out of its key
+func (c *markovChain) generate(seed Motif, rng *rand.Rand) Motif {
+	scale := seed.getScale()
+	sounding, _ := getMotifSteps(seed)
+	value, beat := sounding[0].Value, sounding[0].StartingBeat
+	step := getVariationSteps(seed)[0]
+	var notes []MotifNote
+	if beat > 1 {
+		notes = append(notes, MotifNote{Note: newNote(-1, beat-1), StartingBeat: 1})
+	}
+	for idx := 0; idx+1 < len(sounding); idx++ {
+		notes = append(notes, MotifNote{Note: newNote(value, step.duration), StartingBeat: beat})
+		beat += step.duration
+		if step.rest > 0 {
+			notes = append(notes, MotifNote{Note: newNote(-1, step.rest), StartingBeat: beat})
+			beat += step.rest
+		}
+		if !isValidNoteValue(value + step.interval) {
+			step.interval = -step.interval
+		}
+		value += step.interval
+		if snapped := scale.snap(value, 0); isValidNoteValue(snapped) {
+			value = snapped
+		}
+		step = c.next(step, rng)
+	}
+	last := sounding[len(sounding)-1]
+	notes = append(notes, MotifNote{Note: newNote(value, last.Duration), StartingBeat: beat})
+	v := seed
+	v.Notes = notes
+	// the seed's controllers follow its own notes so they aren't carried over
+	v.PitchBend, v.Modulation = nil, nil
+	return v
+}
+
+// add ornaments to the notes of a motif, each note has a chance of one
+// a note a third from the next is filled in with a passing note and a note of a beat or more
+// with an upper neighbour, both taken from the motif's scale
+func ornamentMotif(m Motif, chance float64, rng *rand.Rand) Motif {
+	scale := m.getScale()
+	unitsPerBeat := getUnitsPerBeat(m.TimeSignature)
+	var notes []MotifNote
+	for idx, n := range m.Notes {
+		if n.Value < 0 || rng.Float64() >= chance {
+			notes = append(notes, n)
+			continue
+		}
+		pos, offset := scale.getScalePosition(n.Value)
+		var next *MotifNote
+		if idx+1 < len(m.Notes) && m.Notes[idx+1].Value >= 0 {
+			next = &m.Notes[idx+1]
+		}
+		half := n.Duration / 2
+		switch {
+		case next != nil && offset == 0 && half > 0 && n.Duration%2 == 0 && isScaleThird(scale, n.Value, next.Value):
+			nextPos, _ := scale.getScalePosition(next.Value)
+			passing := scale.getScaleValue(minInt(pos, nextPos)+1, 0)
+			notes = append(notes,
+				MotifNote{Note: newNote(n.Value, half), StartingBeat: n.StartingBeat},
+				MotifNote{Note: newNote(passing, half), StartingBeat: n.StartingBeat + half})
+		case offset == 0 && n.Duration >= unitsPerBeat && n.Duration%4 == 0 && isValidNoteValue(scale.getScaleValue(pos+1, 0)):
+			quarter := n.Duration / 4
+			notes = append(notes,
+				MotifNote{Note: newNote(n.Value, half), StartingBeat: n.StartingBeat},
+				MotifNote{Note: newNote(scale.getScaleValue(pos+1, 0), quarter), StartingBeat: n.StartingBeat + half},
+				MotifNote{Note: newNote(n.Value, quarter), StartingBeat: n.StartingBeat + half + quarter})
+		default:
+			notes = append(notes, n)
+		}
+	}
+	m.Notes = notes
+	return m
+}
+
+// whether two notes of a scale are a third apart, with one note of the scale between them
+func isScaleThird(scale Scale, a int, b int) bool {
+	posA, offsetA := scale.getScalePosition(a)
+	posB, offsetB := scale.getScalePosition(b)
+	return offsetA == 0 && offsetB == 0 && (posB-posA == 2 || posA-posB == 2)
+}
+
+// take the motifs of a file and return them with variations of its first melodic motif after it,
+// each starting on the bar after the one before ends so they can be heard one after another
+func generateVariations(motifs []Motif, opts VariationOptions) ([]Motif, error) {
+	seed, err := getQueryMotif(motifs)
+	if err != nil {
+		return nil, err
+	}
+	if len(getVariationSteps(seed)) == 0 {
+		return nil, errors.New("seed motif needs at least two notes")
+	}
+	chain := newMarkovChain()
+	chain.learn(seed)
+	for _, m := range opts.Corpus {
+		chain.learn(m)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	unitsPerBar := getUnitsPerBar(seed.TimeSignature)
+	getNextBar := func(m Motif) int {
+		end := m.Offset + getMotifEnd(m)
+		return (end + unitsPerBar - 1) / unitsPerBar * unitsPerBar
+	}
+	variations := []Motif{seed}
+	for idx := 1; idx <= opts.Count; idx++ {
+		v := ornamentMotif(chain.generate(seed, rng), opts.Ornament, rng)
+		v.ID = fmt.Sprintf("variation-%d", idx)
+		v.Name = fmt.Sprintf("variation %d", idx)
+		if seed.Name != "" {
+			v.Name = seed.Name + " " + v.Name
+		}
+		v.Offset = getNextBar(variations[idx-1])
+		v.computeRelativeFields()
+		variations = append(variations, v)
+	}
+	// the other tracks of the file, drums and accompaniment included, keep playing around the seed
+	seedIdx := 0
+	for motifs[seedIdx].Percussion {
+		seedIdx++
+	}
+	var generated []Motif
+	generated = append(generated, motifs[:seedIdx]...)
+	generated = append(generated, variations...)
+	return append(generated, motifs[seedIdx+1:]...), nil
+}
+
+// parse the melodic motifs of MIDI files for a chain to learn from
+// files that fail to parse are skipped so one bad file doesn't stop the rest
+func parseCorpusMotifs(files map[string]string, parse ParseOptions) []Motif {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var corpus []Motif
+	for _, name := range names {
+		motifs, err := parseMotifsFromMIDIFile(files[name], parse)
+		if err != nil {
+			fmt.Println("Skipping", name, err)
+			continue
+		}
+		corpus = append(corpus, motifs...)
+	}
+	return corpus
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// a motif in c major from values and durations, a value of -1 is a rest
+func testMotif(name string, values []int, durations []int) Motif {
+	m := Motif{Name: name, Key: "c", Mode: "major", Tempo: Tempo{Type: "bpm", Units: 120}, TimeSignature: TimeSignature{Beat: 4, Unit: 4}}
+	beat := 1
+	for i, v := range values {
+		m.Notes = append(m.Notes, MotifNote{Note: newNote(v, durations[i]), StartingBeat: beat})
+		beat += durations[i]
+	}
+	return m
+}
+
+func TestGenerateVariationsSeed(t *testing.T) {
+	seed := testMotif("tune", []int{49, 51, 53, 49, 53, 54, 56, -1, 56, 58, 56, 54, 53, 49}, []int{8, 8, 8, 8, 8, 8, 16, 8, 4, 4, 4, 4, 8, 8})
+	drums := testMotif("drums", []int{36, 38, 36, 38}, []int{16, 16, 16, 16})
+	drums.Percussion = true
+	corpus := []Motif{testMotif("corpus", []int{49, 56, 54, 53, 51, 49, 44, 49}, []int{4, 4, 4, 4, 8, 8, 8, 24})}
+	tests := []struct {
+		name string
+		opts VariationOptions
+	}{
+		{"seed 1", VariationOptions{Count: 4, Seed: 1}},
+		{"seed 2", VariationOptions{Count: 4, Seed: 2}},
+		{"negative seed", VariationOptions{Count: 4, Seed: -7}},
+		{"ornaments", VariationOptions{Count: 4, Seed: 1, Ornament: 0.5}},
+		{"corpus", VariationOptions{Count: 4, Seed: 1, Corpus: corpus}},
+		{"corpus and ornaments", VariationOptions{Count: 8, Seed: 42, Ornament: 1, Corpus: corpus}},
+	}
+	outputs := map[string][]Motif{}
+	for _, tt := range tests {
+		first, err := generateVariations([]Motif{drums, seed}, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the same seed generates the same variations every time
+		for run := 0; run < 3; run++ {
+			again, err := generateVariations([]Motif{drums, seed}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(first, again) {
+				t.Errorf("%v: run %d generated different variations", tt.name, run+2)
+				break
+			}
+		}
+		if len(first) != tt.opts.Count+2 || first[0].Name != "drums" || first[1].Name != "tune" {
+			t.Errorf("%v: got %d motifs, want the drums, the seed and %d variations", tt.name, len(first), tt.opts.Count)
+			continue
+		}
+		// each variation starts on the bar after the one before ends
+		for idx := 2; idx < len(first); idx++ {
+			prev, v := first[idx-1], first[idx]
+			if v.Offset%getUnitsPerBar(v.TimeSignature) != 0 || v.Offset < prev.Offset+getMotifEnd(prev) {
+				t.Errorf("%v: variation %d at offset %d, the one before ends at %d", tt.name, idx-1, v.Offset, prev.Offset+getMotifEnd(prev))
+			}
+		}
+		outputs[tt.name] = first
+	}
+	if reflect.DeepEqual(outputs["seed 1"], outputs["seed 2"]) {
+		t.Error("seeds 1 and 2 generated the same variations")
+	}
+}
+
+func TestGenerateVariationsErrors(t *testing.T) {
+	drums := testMotif("drums", []int{36, 38}, []int{16, 16})
+	drums.Percussion = true
+	tests := []struct {
+		name   string
+		motifs []Motif
+	}{
+		{"no melody", []Motif{drums}},
+		{"one note", []Motif{testMotif("tune", []int{49, -1}, []int{16, 16})}},
+	}
+	for _, tt := range tests {
+		if _, err := generateVariations(tt.motifs, VariationOptions{Count: 2, Seed: 1}); err == nil {
+			t.Errorf("%v: didn't fail", tt.name)
+		}
+	}
+}